@@ -30,19 +30,59 @@ func init() {
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// 升级时exec的程序，需要在启动时获取，升级时二进制文件可能已经被替换
+	exe, err := os.Executable()
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := supervisord.LoadUpgradeState(); err != nil {
+		log.Printf("load upgrade state: %s", err.Error())
+	}
+	// 程序fork出的进程在父进程退出之后由supergo回收
+	if err := supervisord.EnableSubreaper(); err != nil {
+		log.Printf("enable subreaper: %s", err.Error())
+	}
 	cfg, err := supervisord.ParseConfigFile(configFile)
 	if err != nil {
 		log.Panic(err)
 	}
+	if cfg.Supergo.StateFile != "" {
+		if err := supervisord.SetStateFile(cfg.Supergo.StateFile); err != nil {
+			log.Printf("load state file %s: %s", cfg.Supergo.StateFile, err.Error())
+		}
+	}
+	// cgroup不可用时程序仍然可以运行，只是没有资源限制
+	if err := supervisord.SetCgroupRoot(cfg.Supergo.CgroupRoot); err != nil {
+		log.Printf("warning: %s", err.Error())
+	}
 	super := supervisord.NewSupervisor(cfg)
-	for name, p := range cfg.ProgramConfigs {
-		p, err := super.AddProgram(name, p)
+	inherited := make(map[string]bool)
+	for name, pcfg := range cfg.ProgramConfigs {
+		p, err := super.AddProgram(name, pcfg)
 		if err != nil {
 			log.Printf("add program %s: %s", name, err.Error())
 			continue
 		}
-		p.StartProcess()
+		// 升级之后接管升级之前的进程，进程都在运行，不需要按照依赖的顺序
+		if ok, err := p.Inherit(); ok {
+			if err != nil {
+				log.Printf("inherit program %s: %s", name, err.Error())
+			}
+			inherited[name] = true
+		}
 	}
+	supervisord.ReleaseUpgradeState()
+	// 接管上一次运行时启动的进程，避免重复启动，autostart只决定是否启动新的进程
+	for name := range super.AdoptAll() {
+		inherited[name] = true
+	}
+	// 等待依赖的程序就绪可能需要较长的时间，不阻塞API
+	go super.StartAll(func(p *supervisord.Program) error {
+		if inherited[p.Name] {
+			return nil
+		}
+		return p.StartProcess()
+	})
 
 	l, err := net.Listen("tcp", listenAddr)
 	if err != nil {
@@ -56,7 +96,7 @@ func main() {
 	go apiServer.ServeHTTP(l)
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT, syscall.SIGSTOP)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT, syscall.SIGSTOP, syscall.SIGUSR1, syscall.SIGUSR2)
 	for {
 		s := <-c
 		log.Printf("get a signal %s", s.String())
@@ -65,6 +105,15 @@ func main() {
 			super.Exit()
 			log.Printf("exit")
 			return
+		case syscall.SIGUSR2:
+			// 升级成功时不会返回
+			log.Printf("upgrade to %s", exe)
+			if err := super.Upgrade(exe); err != nil {
+				log.Printf("upgrade: %s", err.Error())
+			}
+		case syscall.SIGUSR1:
+			// 日志文件被外部的工具移走之后，重新打开
+			super.ReopenLogs()
 		case syscall.SIGHUP:
 		default:
 			return
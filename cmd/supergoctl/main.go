@@ -5,9 +5,14 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/iampastor/supergo/supervisord"
@@ -25,9 +30,15 @@ Commands:
 supergoctl status
 supergoctl reread
 supergoctl update
-supergoctl start <prog>
-supergoctl stop <prog>
-supergoctl restart <prog>
+supergoctl upgrade
+supergoctl logrotate // 重新打开所有的日志文件
+supergoctl start <prog|group:*|all|web-*>
+supergoctl stop <prog|group:*|all|web-*>
+supergoctl restart <prog|group:*|all|web-*>
+supergoctl restart --rolling [--batch 1] [--pause 5s] <prog>
+supergoctl history <prog>
+supergoctl env <prog>
+supergoctl tail [-f] [--stderr] <prog>
 `
 )
 
@@ -57,9 +68,17 @@ func main() {
 			reread()
 		case "update":
 			update()
+		case "upgrade":
+			upgrade()
+		case "logrotate":
+			logrotate()
 		default:
-			fmt.Fprintf(os.Stderr, usage)
+			fmt.Fprint(os.Stderr, usage)
 		}
+	} else if flag.NArg() >= 2 && flag.Arg(0) == "restart" && strings.HasPrefix(flag.Arg(1), "-") {
+		rollingRestart(flag.Args()[1:])
+	} else if flag.NArg() >= 2 && flag.Arg(0) == "tail" {
+		tail(flag.Args()[1:])
 	} else if flag.NArg() == 2 {
 		cmd := flag.Arg(0)
 		name := flag.Arg(1)
@@ -70,11 +89,15 @@ func main() {
 			stop(name)
 		case "restart":
 			restart(name)
+		case "history":
+			history(name)
+		case "env":
+			env(name)
 		default:
-			fmt.Fprintf(os.Stderr, usage)
+			fmt.Fprint(os.Stderr, usage)
 		}
 	} else {
-		fmt.Fprintf(os.Stderr, usage)
+		fmt.Fprint(os.Stderr, usage)
 	}
 }
 
@@ -102,7 +125,12 @@ func get(cmd string) (*ApiResponse, error) {
 }
 
 func post(cmd string, name string) (*ApiResponse, error) {
-	resp, err := client.PostForm(fmt.Sprintf("%s/%s/%s", urlAddr, cmd, name), nil)
+	return postForm(cmd, name, nil)
+}
+
+// 失败时也返回响应，其中可能包含失败的详情
+func postForm(cmd string, name string, form url.Values) (*ApiResponse, error) {
+	resp, err := client.PostForm(fmt.Sprintf("%s/%s/%s", urlAddr, cmd, name), form)
 	if err != nil {
 		return nil, err
 	}
@@ -113,7 +141,7 @@ func post(cmd string, name string) (*ApiResponse, error) {
 	apiResp := new(ApiResponse)
 	json.Unmarshal(data, apiResp)
 	if apiResp.Status != 0 {
-		return nil, errors.New(apiResp.Message)
+		return apiResp, errors.New(apiResp.Message)
 	}
 	return apiResp, nil
 }
@@ -126,12 +154,15 @@ func status() {
 	}
 	var progStatus []*supervisord.ProgramStatus
 	if err := json.Unmarshal(resp.Data, &progStatus); err != nil {
-		fmt.Fprintf(os.Stderr, err.Error()+string(resp.Data))
+		fmt.Fprint(os.Stderr, err.Error()+string(resp.Data))
 	}
 	for _, ps := range progStatus {
-		if ps.State == supervisord.ProcessStateRunning {
-			fmt.Fprintln(os.Stderr, fmt.Sprintf("%-30s\t%-8s\tpid %-5d\tstart at %s\tlisteners %v", ps.Name, ps.State, ps.Pid,
-				time.Unix(ps.StartTime, 0).Format("2006-01-02 15:04:05"), ps.Listeners))
+		if ps.State == supervisord.ProcessStateRunning || ps.State == supervisord.ProcessStateRestartFailed {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("%-30s\t%-8s\tpid %-5d\tstart at %s\tlisteners %v\t%s", ps.Name, ps.State, ps.Pid,
+				time.Unix(ps.StartTime, 0).Format("2006-01-02 15:04:05"), ps.Listeners, ps.StatusText))
+		} else if ps.State == supervisord.ProcessStateBackoff {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("%-30s\t%-8s\tpid %-5d\tretry at %s\tlisteners %v", ps.Name, ps.State, ps.Pid,
+				time.Unix(ps.NextRetryTime, 0).Format("2006-01-02 15:04:05"), ps.Listeners))
 		} else if ps.State == supervisord.ProcessStateStopped {
 			fmt.Fprintln(os.Stderr, fmt.Sprintf("%-30s\t%-8s\tpid %-5d\tstop at %s \tlisteners %v", ps.Name, ps.State, ps.Pid,
 				time.Unix(ps.StopTime, 0).Format("2006-01-02 15:04:05"), ps.Listeners))
@@ -139,6 +170,51 @@ func status() {
 			fmt.Fprintln(os.Stderr, fmt.Sprintf("%-30s\t%-8s\tpid %-5d\tend at %s  \tlisteners %v", ps.Name, ps.State, ps.Pid,
 				time.Unix(ps.StopTime, 0).Format("2006-01-02 15:04:05"), ps.Listeners))
 		}
+		if len(ps.Orphans) != 0 {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("%-30s	orphans %v", "", ps.Orphans))
+		}
+		if cg := ps.Cgroup; cg != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("%-30s	memory %.1fM\tcpu %.1fs\toom_kills %d", "",
+				float64(cg.MemoryCurrent)/(1<<20), float64(cg.CPUUsageUsec)/1e6, cg.OOMKills))
+		}
+	}
+}
+
+func history(name string) {
+	resp, err := get("history/" + name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	var transitions []*supervisord.StateTransition
+	if err := json.Unmarshal(resp.Data, &transitions); err != nil {
+		fmt.Fprint(os.Stderr, err.Error()+string(resp.Data))
+	}
+	for _, t := range transitions {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("%s\t%-13s -> %-13s\t%s", time.Unix(t.Time, 0).Format("2006-01-02 15:04:05"),
+			t.From, t.To, t.Reason))
+	}
+}
+
+func env(name string) {
+	resp, err := get("env/" + name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	if resp.Status != 0 {
+		fmt.Fprintln(os.Stderr, resp.Message)
+		return
+	}
+	env := make(map[string]string)
+	json.Unmarshal(resp.Data, &env)
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(os.Stderr, "%s=%s\n", k, env[k])
 	}
 }
 
@@ -174,8 +250,8 @@ func update() {
 	fmt.Fprintln(os.Stderr, string(resp.Message))
 }
 
-func start(name string) {
-	resp, err := post("start", name)
+func upgrade() {
+	resp, err := post("upgrade", "")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
@@ -183,8 +259,8 @@ func start(name string) {
 	fmt.Fprintln(os.Stderr, string(resp.Message))
 }
 
-func stop(name string) {
-	resp, err := post("stop", name)
+func logrotate() {
+	resp, err := post("logrotate", "")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
@@ -192,11 +268,116 @@ func stop(name string) {
 	fmt.Fprintln(os.Stderr, string(resp.Message))
 }
 
-func restart(name string) {
-	resp, err := post("restart", name)
+func start(name string) {
+	command("start", name)
+}
+
+func stop(name string) {
+	command("stop", name)
+}
+
+// 执行start、stop、restart，目标为多个程序时逐个显示结果
+func command(cmd string, name string) {
+	resp, err := post(cmd, name)
+	if resp != nil && len(resp.Data) != 0 {
+		var results []*supervisord.ProgramResult
+		if json.Unmarshal(resp.Data, &results) == nil && len(results) > 1 {
+			for _, r := range results {
+				result := "success"
+				if r.Error != "" {
+					result = r.Error
+				}
+				fmt.Fprintln(os.Stderr, fmt.Sprintf("%-30s\t%s", r.Name, result))
+			}
+		}
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
 	}
 	fmt.Fprintln(os.Stderr, string(resp.Message))
 }
+
+func rollingRestart(args []string) {
+	fs := flag.NewFlagSet("restart", flag.ExitOnError)
+	rolling := fs.Bool("rolling", false, "restart in batches")
+	batch := fs.Int("batch", 1, "number of processes restarted at the same time")
+	pause := fs.Duration("pause", 0, "pause between batches")
+	fs.Parse(args)
+	if !*rolling || fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return
+	}
+	form := url.Values{
+		"rolling": {"1"},
+		"batch":   {strconv.Itoa(*batch)},
+		"pause":   {pause.String()},
+	}
+	resp, err := postForm("restart", fs.Arg(0), form)
+	if resp != nil && len(resp.Data) != 0 {
+		var report supervisord.RollingReport
+		if json.Unmarshal(resp.Data, &report) == nil {
+			names := make([]string, 0, len(report.Failed))
+			for name := range report.Failed {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("restarted %v", report.Restarted))
+			for _, name := range names {
+				fmt.Fprintln(os.Stderr, fmt.Sprintf("failed    %s: %s", name, report.Failed[name]))
+			}
+			if len(report.Skipped) != 0 {
+				fmt.Fprintln(os.Stderr, fmt.Sprintf("skipped   %v", report.Skipped))
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	fmt.Fprintln(os.Stderr, resp.Message)
+}
+
+// 输出程序在内存中保留的输出，-f时持续输出新的内容
+func tail(args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	follow := fs.Bool("f", false, "output appended data as the log grows")
+	stderr := fs.Bool("stderr", false, "read stderr instead of stdout")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return
+	}
+	name := fs.Arg(0)
+	query := url.Values{"stream": {"stdout"}}
+	if *stderr {
+		query.Set("stream", "stderr")
+	}
+	resp, err := get("logs/" + name + "?" + query.Encode())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	var chunk supervisord.LogChunk
+	if err := json.Unmarshal(resp.Data, &chunk); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	fmt.Fprint(os.Stdout, chunk.Data)
+	if !*follow {
+		return
+	}
+	query.Set("follow", "1")
+	query.Set("offset", strconv.FormatInt(chunk.Next, 10))
+	stream, err := client.Get(fmt.Sprintf("%s/logs/%s?%s", urlAddr, name, query.Encode()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	defer stream.Body.Close()
+	io.Copy(os.Stdout, stream.Body)
+}
+
+func restart(name string) {
+	command("restart", name)
+}
@@ -0,0 +1,260 @@
+package supervisord
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 检查接管的进程是否退出的间隔
+const adoptPollInterval = time.Second
+
+var ErrNoProcessRecord = errors.New("no live process to adopt")
+
+// 状态文件中记录的进程，supergo重新启动之后根据该记录接管仍在运行的进程
+type processRecord struct {
+	Pid           int    `json:"pid"`
+	ProcStartTime uint64 `json:"proc_start_time"` // /proc中进程的启动时间，防止pid被复用
+	StartTime     int64  `json:"start_time"`
+	NotifySocket  string `json:"notify_socket,omitempty"`
+}
+
+type processStore struct {
+	lock    sync.Mutex
+	path    string
+	records map[string]*processRecord
+	stopped map[string]bool // 被手动停止的程序，supergo重新启动或者更新配置之后也不会启动
+}
+
+// 状态文件的内容
+type stateFile struct {
+	Processes map[string]*processRecord `json:"processes"`
+	Stopped   []string                  `json:"stopped,omitempty"`
+}
+
+var processStates = &processStore{records: make(map[string]*processRecord), stopped: make(map[string]bool)}
+
+// 设置状态文件并读取上一次运行时记录的进程
+func SetStateFile(path string) error {
+	processStates.lock.Lock()
+	defer processStates.lock.Unlock()
+	processStates.path = path
+	processStates.records = make(map[string]*processRecord)
+	processStates.stopped = make(map[string]bool)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Processes != nil {
+		processStates.records = state.Processes
+	}
+	for _, name := range state.Stopped {
+		processStates.stopped[name] = true
+	}
+	return nil
+}
+
+func (s *processStore) get(name string) *processRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.records[name]
+}
+
+func (s *processStore) set(name string, rec *processRecord) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.path == "" {
+		return
+	}
+	s.records[name] = rec
+	s.save()
+}
+
+// 只删除pid相同的记录，重启时老进程退出不会删除新进程的记录
+func (s *processStore) remove(name string, pid int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if rec, ok := s.records[name]; !ok || rec.Pid != pid {
+		return
+	}
+	delete(s.records, name)
+	s.save()
+}
+
+// 先写临时文件再rename，supergo在写入时崩溃也不会损坏状态文件，调用时需要持有s.lock
+func (s *processStore) save() {
+	if s.path == "" {
+		return
+	}
+	state := stateFile{Processes: s.records}
+	for name := range s.stopped {
+		state.Stopped = append(state.Stopped, name)
+	}
+	sort.Strings(state.Stopped)
+	data, _ := json.Marshal(state)
+	tmp := s.path + ".tmp"
+	err := os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err == nil {
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		log.Printf("save state file %s: %s", s.path, err.Error())
+	}
+}
+
+// 记录程序当前的进程，调用时需要持有program.lock
+func (program *Program) recordProcess(process *Process) {
+	if !program.cfg.Adopt || process.pid == 0 {
+		return
+	}
+	procStart, err := procStartTime(process.pid)
+	if err != nil {
+		program.logger.Printf("record process %d: %s", process.pid, err.Error())
+		return
+	}
+	rec := &processRecord{Pid: process.pid, ProcStartTime: procStart, StartTime: process.startTime}
+	if process.notify != nil {
+		rec.NotifySocket = process.notify.path
+	}
+	processStates.set(program.Name, rec)
+}
+
+// 接管状态文件中记录的仍在运行的进程，而不是启动一个新的进程
+func (program *Program) AdoptProcess() error {
+	program.cmdLock.Lock()
+	defer program.cmdLock.Unlock()
+
+	rec := processStates.get(program.Name)
+	if rec == nil {
+		return ErrNoProcessRecord
+	}
+	if procStart, err := procStartTime(rec.Pid); err != nil || procStart != rec.ProcStartTime {
+		processStates.remove(program.Name, rec.Pid)
+		return ErrNoProcessRecord
+	}
+
+	process := &Process{
+		pid:       rec.Pid,
+		adopted:   true,
+		startTime: rec.StartTime,
+	}
+	// 在原来的路径上重新创建socket，进程仍然可以发送通知
+	var notify *notifySocket
+	if program.cfg.Notify && rec.NotifySocket != "" {
+		var err error
+		notify, err = listenNotifyAt(rec.NotifySocket)
+		if err != nil {
+			program.logger.Printf("listen notify socket %s: %s", rec.NotifySocket, err.Error())
+		}
+	}
+	// 端口由接管的进程监听，supergo自己的listener在下一次启动新进程时再创建
+	program.lock.Lock()
+	program.closeListener()
+	program.lock.Unlock()
+	return program.attachProcess(process, notify, "adopt")
+}
+
+// 接管所有配置了adopt并且记录的进程仍在运行的程序，返回接管了的程序。
+// 不论是否开启autostart都接管，否则之后手动启动时会在仍在运行的老进程之外再启动一个进程
+func (supervisor *Supervisor) AdoptAll() map[string]bool {
+	adopted := make(map[string]bool)
+	for _, prog := range supervisor.ListPrograms() {
+		// 升级时已经接管的程序不是Stopped
+		if !prog.cfg.Adopt || prog.Status().State != ProcessStateStopped {
+			continue
+		}
+		err := prog.AdoptProcess()
+		if err == nil {
+			adopted[prog.Name] = true
+		} else if err != ErrNoProcessRecord {
+			log.Printf("adopt program %s: %s", prog.Name, err.Error())
+		}
+	}
+	return adopted
+}
+
+// 接管一个已经在运行的进程，调用时需要持有program.cmdLock
+func (program *Program) attachProcess(process *Process, notify *notifySocket, reason string) error {
+	process.stopChan = make(chan struct{})
+	process.readyChan = make(chan struct{})
+	process.healthyChan = make(chan struct{})
+	process.notifyReadyChan = make(chan struct{})
+	process.notify = notify
+
+	program.lock.Lock()
+	switch program.status.State {
+	case ProcessStateStopped, ProcessStateExited, ProcessStateFatal:
+	default:
+		program.lock.Unlock()
+		if notify != nil {
+			notify.Close()
+		}
+		return &StateTransitionError{Program: program.Name, From: program.status.State, To: ProcessStateStarting}
+	}
+	program.transition(ProcessStateStarting, reason)
+	program.gen++
+	process.gen = program.gen
+	program.process = process
+	program.status.Pid = process.pid
+	program.status.StartTime = process.startTime
+	program.lock.Unlock()
+	program.logger.Printf("%s process %d", reason, process.pid)
+
+	if notify != nil {
+		go program.readNotify(process)
+	}
+	go program.waitProcess(process)
+	if program.cfg.HealthCheck != nil {
+		go program.checkHealth(process)
+	}
+	go program.runProcess(process, process.gen)
+	return nil
+}
+
+// 接管的进程不是supergo的子进程，不能wait，只能轮询进程是否还存在，退出码未知，
+// 升级时接管的进程仍然是supergo的子进程，可以wait
+func (process *Process) waitAdopted() (int, error) {
+	if process.proc != nil {
+		state, err := process.proc.Wait()
+		childReaper.removeChild(process.pid)
+		if err != nil {
+			return 0, err
+		}
+		return getExitCode(state.Sys())
+	}
+	start, err := procStartTime(process.pid)
+	for err == nil {
+		time.Sleep(adoptPollInterval)
+		var now uint64
+		now, err = procStartTime(process.pid)
+		if err == nil && now != start {
+			break
+		}
+	}
+	return -1, nil
+}
+
+// 接管模式下使用SO_REUSEPORT监听，supergo重新启动之后，仍在运行的老进程监听着相同的端口
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var err error
+	c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	return err
+}
@@ -0,0 +1,140 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 模拟supergo重新启动：新的Program根据状态文件接管老Program启动的进程
+func Test_AdoptProcess(t *testing.T) {
+	os.Setenv(helperEnv, "1")
+	defer os.Unsetenv(helperEnv)
+	dir, err := ioutil.TempDir("", "supergo-adopt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+	if err := SetStateFile(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	defer SetStateFile("")
+
+	cfg := helperConfig()
+	cfg.Adopt = true
+	cfg.AutoRestart = false
+	cfg.ListenAddrs = []string{"127.0.0.1:0"}
+	old, err := NewProgram("adopt", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool { return old.Status().State == ProcessStateRunning }, "process is not running")
+	pid := old.Status().Pid
+
+	// 重新读取状态文件
+	if err := SetStateFile(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := NewProgram("adopt", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.AdoptProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool { return prog.Status().State == ProcessStateRunning }, "adopted process is not running")
+	if prog.Status().Pid != pid {
+		t.Fatalf("adopted pid %d, want %d", prog.Status().Pid, pid)
+	}
+	if err := prog.AdoptProcess(); err == nil {
+		t.Fatal("adopt twice")
+	}
+
+	if err := prog.StopProcess(); err != nil {
+		t.Fatal(err)
+	}
+	if prog.Status().State != ProcessStateStopped {
+		t.Fatalf("state %s, want Stopped", prog.Status().State)
+	}
+	waitFor(t, time.Second*5, func() bool { return old.Status().State == ProcessStateExited }, "old program does not see the exit")
+
+	// 进程退出之后，记录也应该被删除
+	if err := SetStateFile(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.AdoptProcess(); err != ErrNoProcessRecord {
+		t.Fatalf("got %v, want ErrNoProcessRecord", err)
+	}
+}
+
+// 没有开启autostart但是被手动启动的程序，supergo重新启动之后同样接管，不会再启动一个进程
+func Test_AdoptAllWithoutAutostart(t *testing.T) {
+	os.Setenv(helperEnv, "1")
+	defer os.Unsetenv(helperEnv)
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	if err := SetStateFile(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	defer SetStateFile("")
+
+	off := false
+	cfg := helperConfig()
+	cfg.Adopt = true
+	cfg.AutoRestart = false
+	cfg.Autostart = &off
+	old, err := NewProgram("manual", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer old.StopProcess()
+	waitFor(t, time.Second*5, func() bool { return old.Status().State == ProcessStateRunning }, "process is not running")
+	pid := old.Status().Pid
+
+	// 模拟supergo重新启动
+	if err := SetStateFile(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	if _, err := supervisor.AddProgram("manual", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if adopted := supervisor.AdoptAll(); !adopted["manual"] {
+		t.Fatalf("got adopted %v", adopted)
+	}
+	if s := supervisor.GetProgram("manual").Status(); s.Pid != pid {
+		t.Fatalf("adopted pid %d, want %d", s.Pid, pid)
+	}
+}
+
+// 没有配置state_file时无法记录进程，adopt的配置被拒绝
+func Test_AdoptRequiresStateFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "supergo.toml")
+	ioutil.WriteFile(file, []byte(`
+[program.api]
+command = "./api"
+adopt = true
+`), 0644)
+	if _, err := ParseConfigFile(file); err == nil {
+		t.Fatal("expect error for adopt without state_file")
+	}
+	ioutil.WriteFile(file, []byte(`
+[supergo]
+state_file = "/var/run/supergo/state.json"
+[program.api]
+command = "./api"
+adopt = true
+`), 0644)
+	if _, err := ParseConfigFile(file); err != nil {
+		t.Fatal(err)
+	}
+}
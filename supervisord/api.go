@@ -2,8 +2,14 @@ package supervisord
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -16,11 +22,16 @@ type APIServer struct {
 func (s *APIServer) ServeHTTP(l net.Listener) error {
 	mu := httprouter.New()
 	mu.Handle(http.MethodGet, "/status", s.getStatus)
+	mu.Handle(http.MethodGet, "/history/:name", s.getHistory)
+	mu.Handle(http.MethodGet, "/env/:name", s.getEnv)
+	mu.Handle(http.MethodGet, "/logs/:name", s.getLogs)
 	mu.Handle(http.MethodGet, "/reread", s.reReadConfig)
 	mu.Handle(http.MethodPost, "/update", s.updatePrograms)
 	mu.Handle(http.MethodPost, "/start/:name", s.startProgram)
 	mu.Handle(http.MethodPost, "/stop/:name", s.stopProgram)
 	mu.Handle(http.MethodPost, "/restart/:name", s.restartProgram)
+	mu.Handle(http.MethodPost, "/upgrade", s.upgrade)
+	mu.Handle(http.MethodPost, "/logrotate", s.logrotate)
 
 	serv := http.Server{
 		Handler: mu,
@@ -43,7 +54,12 @@ func (r *HttpResponse) ToJson() []byte {
 func (s *APIServer) restartProgram(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	resp := new(HttpResponse)
 	name := params.ByName("name")
-	err := s.RestartProgram(name)
+	if req.FormValue("rolling") != "" {
+		s.rollingRestart(w, req, name)
+		return
+	}
+	results, err := s.RestartPrograms(name)
+	resp.Data = results
 	if err != nil {
 		resp.Status = 1
 		resp.Message = err.Error()
@@ -55,6 +71,41 @@ func (s *APIServer) restartProgram(w http.ResponseWriter, req *http.Request, par
 	w.Write(resp.ToJson())
 }
 
+// 滚动重启，batch为每批重启的程序数，pause为每批之间暂停的时间，如5s
+func (s *APIServer) rollingRestart(w http.ResponseWriter, req *http.Request, name string) {
+	resp := new(HttpResponse)
+	batch, pause, err := parseRollingParams(req)
+	if err == nil {
+		var report *RollingReport
+		report, err = s.RollingRestart(name, batch, pause)
+		resp.Data = report
+	}
+	if err != nil {
+		resp.Status = 1
+		resp.Message = err.Error()
+		w.Write(resp.ToJson())
+		return
+	}
+	resp.Message = "success"
+	w.Write(resp.ToJson())
+}
+
+func parseRollingParams(req *http.Request) (int, time.Duration, error) {
+	batch, pause := 1, time.Duration(0)
+	var err error
+	if v := req.FormValue("batch"); v != "" {
+		if batch, err = strconv.Atoi(v); err != nil || batch <= 0 {
+			return 0, 0, fmt.Errorf("invalid batch %q", v)
+		}
+	}
+	if v := req.FormValue("pause"); v != "" {
+		if pause, err = time.ParseDuration(v); err != nil || pause < 0 {
+			return 0, 0, fmt.Errorf("invalid pause %q", v)
+		}
+	}
+	return batch, pause, nil
+}
+
 func (s *APIServer) getStatus(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	resp := new(HttpResponse)
 	status := s.GetStatus()
@@ -63,10 +114,41 @@ func (s *APIServer) getStatus(w http.ResponseWriter, req *http.Request, params h
 	w.Write(resp.ToJson())
 }
 
+func (s *APIServer) getHistory(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	resp := new(HttpResponse)
+	history, err := s.GetHistory(params.ByName("name"))
+	if err != nil {
+		resp.Status = 1
+		resp.Message = err.Error()
+		w.Write(resp.ToJson())
+		return
+	}
+	resp.Message = "success"
+	resp.Data = history
+	w.Write(resp.ToJson())
+}
+
+// 密钥类的环境变量会被隐藏
+func (s *APIServer) getEnv(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	resp := new(HttpResponse)
+	env, err := s.GetEnv(params.ByName("name"))
+	if err != nil {
+		resp.Status = 1
+		resp.Message = err.Error()
+		w.Write(resp.ToJson())
+		return
+	}
+	resp.Message = "success"
+	resp.Data = env
+	w.Write(resp.ToJson())
+}
+
 func (s *APIServer) startProgram(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	resp := new(HttpResponse)
 	name := params.ByName("name")
-	if err := s.StartProgram(name); err != nil {
+	results, err := s.StartPrograms(name)
+	resp.Data = results
+	if err != nil {
 		resp.Status = 1
 		resp.Message = err.Error()
 		w.Write(resp.ToJson())
@@ -79,7 +161,9 @@ func (s *APIServer) startProgram(w http.ResponseWriter, req *http.Request, param
 func (s *APIServer) stopProgram(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	resp := new(HttpResponse)
 	name := params.ByName("name")
-	if err := s.StopProgram(name); err != nil {
+	results, err := s.StopPrograms(name)
+	resp.Data = results
+	if err != nil {
 		resp.Status = 1
 		resp.Message = err.Error()
 		w.Write(resp.ToJson())
@@ -98,6 +182,7 @@ func (s *APIServer) updatePrograms(w http.ResponseWriter, req *http.Request, par
 		w.Write(resp.ToJson())
 		return
 	}
+	s.SetGroups(cfg.Groups)
 	err = s.Reload(cfg.ProgramConfigs)
 	if err != nil {
 		resp.Status = 1
@@ -137,3 +222,107 @@ func (s *APIServer) reReadConfig(w http.ResponseWriter, req *http.Request, param
 	resp.Message = "success"
 	w.Write(resp.ToJson())
 }
+
+// 通过SIGUSR2通知supergo升级，与直接发送信号的流程相同，需要在exec之前将响应完整的发送出去
+func (s *APIServer) upgrade(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	resp := new(HttpResponse)
+	resp.Message = "upgrading"
+	data := resp.ToJson()
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+}
+
+// 重新打开所有程序的日志文件
+func (s *APIServer) logrotate(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	resp := new(HttpResponse)
+	s.Supervisor.ReopenLogs()
+	resp.Message = "success"
+	w.Write(resp.ToJson())
+}
+
+// 读取程序在内存中保留的输出，stream为stdout或stderr，offset为上一次返回的next，
+// follow时持续输出新的内容直到连接断开，Accept为text/event-stream时使用SSE，否则直接输出
+func (s *APIServer) getLogs(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	resp := new(HttpResponse)
+	name := params.ByName("name")
+	stream := req.FormValue("stream")
+	if stream == "" {
+		stream = streamStdout
+	}
+	// SSE的客户端重连时通过Last-Event-ID传递上一次的offset
+	offset := int64(-1)
+	var err error
+	v := req.FormValue("offset")
+	if v == "" {
+		v = req.Header.Get("Last-Event-ID")
+	}
+	if v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil {
+			err = fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	follow := req.FormValue("follow") != ""
+	var chunk *LogChunk
+	var b *ringBuffer
+	if err == nil {
+		if follow {
+			b, err = s.logBufferOf(name, stream)
+		} else {
+			chunk, err = s.ReadLog(name, stream, offset)
+		}
+	}
+	if err != nil {
+		resp.Status = 1
+		resp.Message = err.Error()
+		w.Write(resp.ToJson())
+		return
+	}
+	if !follow {
+		resp.Message = "success"
+		resp.Data = chunk
+		w.Write(resp.ToJson())
+		return
+	}
+
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	flusher, _ := w.(http.Flusher)
+	for {
+		// 先获取channel再读取，保证不会错过两者之间写入的内容
+		changed, open := b.watch()
+		data, _, next := b.read(offset)
+		if len(data) != 0 {
+			if sse {
+				// 每一行作为一个data字段，客户端用换行拼接之后即为原始的内容，id为下一次读取的offset
+				fmt.Fprintf(w, "id: %d\n", next)
+				for _, line := range strings.Split(string(data), "\n") {
+					fmt.Fprintf(w, "data: %s\n", line)
+				}
+				fmt.Fprint(w, "\n")
+			} else if _, err := w.Write(data); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		offset = next
+		if !open {
+			return
+		}
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package supervisord
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cgroup v2中每个程序使用的控制器
+var cgroupControllers = []string{"memory", "cpu", "pids"}
+
+// cpu_max以百分比配置时使用的周期，单位微秒，同内核的默认值
+const cgroupCPUPeriod = 100000
+
+var (
+	cgroupLock sync.Mutex
+	cgroupRoot string // 委托给supergo的cgroup v2目录，为空时不使用cgroup
+)
+
+// 设置委托给supergo的cgroup v2目录，每个程序在其中创建一个子cgroup，
+// 目录不可用时只打印警告，程序仍然可以正常运行
+func SetCgroupRoot(root string) error {
+	cgroupLock.Lock()
+	defer cgroupLock.Unlock()
+	cgroupRoot = ""
+	if root == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("cgroup v2 is not available at %s: %s", root, err.Error())
+	}
+	available := strings.Fields(string(data))
+	for _, c := range cgroupControllers {
+		if !contains(available, c) {
+			log.Printf("cgroup controller %s is not available at %s", c, root)
+			continue
+		}
+		// 子cgroup中才能使用这些控制器
+		if err := ioutil.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+"+c), 0644); err != nil {
+			log.Printf("enable cgroup controller %s: %s", c, err.Error())
+		}
+	}
+	cgroupRoot = root
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析内存大小，支持K、M、G、T后缀以及max
+func parseMemorySize(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "max" {
+		return "max", nil
+	}
+	n, err := parseByteSize(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid memory size %q", s)
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+// 解析cpu_max，支持百分比（可以大于100%表示多个核）、"$MAX $PERIOD"以及max
+func parseCPUMax(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "max" {
+		return "max", nil
+	}
+	if strings.HasSuffix(s, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || pct <= 0 {
+			return "", fmt.Errorf("invalid cpu_max %q", s)
+		}
+		return fmt.Sprintf("%d %d", int64(pct*cgroupCPUPeriod/100), cgroupCPUPeriod), nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("invalid cpu_max %q", s)
+	}
+	if _, err := strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return "", fmt.Errorf("invalid cpu_max %q", s)
+	}
+	if _, err := strconv.ParseUint(fields[0], 10, 64); err != nil && fields[0] != "max" {
+		return "", fmt.Errorf("invalid cpu_max %q", s)
+	}
+	return s, nil
+}
+
+func (cfg *ProgramConfig) checkCgroup() error {
+	if _, err := parseMemorySize(cfg.MemoryMax); err != nil {
+		return err
+	}
+	if _, err := parseCPUMax(cfg.CPUMax); err != nil {
+		return err
+	}
+	if cfg.CPUWeight != 0 && (cfg.CPUWeight < 1 || cfg.CPUWeight > 10000) {
+		return errors.New("cpu_weight must be between 1 and 10000")
+	}
+	if cfg.PidsMax < 0 {
+		return errors.New("pids_max must not be negative")
+	}
+	return nil
+}
+
+// 创建程序的cgroup并设置资源限制，返回cgroup的目录，cgroup不可用时返回空
+func (program *Program) setupCgroup() string {
+	cgroupLock.Lock()
+	root := cgroupRoot
+	cgroupLock.Unlock()
+	if root == "" {
+		return ""
+	}
+	dir := filepath.Join(root, program.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		program.logger.Printf("create cgroup %s: %s", dir, err.Error())
+		return ""
+	}
+	// 没有配置的限制也要写入默认值，配置被删除之后重启即可生效
+	memoryMax, _ := parseMemorySize(program.cfg.MemoryMax)
+	cpuMax, _ := parseCPUMax(program.cfg.CPUMax)
+	cpuWeight := "100"
+	if program.cfg.CPUWeight != 0 {
+		cpuWeight = strconv.Itoa(program.cfg.CPUWeight)
+	}
+	pidsMax := "max"
+	if program.cfg.PidsMax != 0 {
+		pidsMax = strconv.Itoa(program.cfg.PidsMax)
+	}
+	for _, kv := range [][2]string{
+		{"memory.max", memoryMax},
+		{"cpu.max", cpuMax},
+		{"cpu.weight", cpuWeight},
+		{"pids.max", pidsMax},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, kv[0]), []byte(kv[1]), 0644); err != nil {
+			program.logger.Printf("set cgroup %s: %s", kv[0], err.Error())
+		}
+	}
+	return dir
+}
+
+// 程序的进程退出之后删除cgroup，其中还有进程时删除会失败
+func (program *Program) removeCgroup() {
+	program.lock.Lock()
+	dir := program.takeCgroup()
+	program.lock.Unlock()
+	removeCgroupDir(dir)
+}
+
+// 取出程序的cgroup，调用时需要持有program.lock，由调用者释放锁之后调用removeCgroupDir
+func (program *Program) takeCgroup() string {
+	dir := program.cgroupDir
+	program.cgroupDir = ""
+	return dir
+}
+
+func removeCgroupDir(dir string) {
+	if dir != "" {
+		os.Remove(dir)
+	}
+}
+
+// cgroup中的资源使用情况
+type CgroupStats struct {
+	MemoryCurrent uint64 `json:"memory_current"` // 当前使用的内存，单位字节
+	CPUUsageUsec  uint64 `json:"cpu_usage_usec"` // 累计使用的CPU时间，单位微秒
+	OOMKills      uint64 `json:"oom_kills"`      // 被OOM killer杀死的进程数
+}
+
+func readCgroupStats(dir string) *CgroupStats {
+	stats := new(CgroupStats)
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "memory.current")); err == nil {
+		stats.MemoryCurrent, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	stats.CPUUsageUsec = readCgroupKey(filepath.Join(dir, "cpu.stat"), "usage_usec")
+	stats.OOMKills = readCgroupKey(filepath.Join(dir, "memory.events"), "oom_kill")
+	return stats
+}
+
+// 读取"key value"格式的文件中的值
+func readCgroupKey(file, key string) uint64 {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			v, _ := strconv.ParseUint(fields[1], 10, 64)
+			return v
+		}
+	}
+	return 0
+}
+
+// 进程退出时，cgroup中OOM kill的次数增加，说明进程因为内存超限被杀死
+func (program *Program) checkOOMKill(process *Process) {
+	program.lock.Lock()
+	dir := program.cgroupDir
+	program.lock.Unlock()
+	if dir == "" {
+		return
+	}
+	if kills := readCgroupStats(dir).OOMKills; kills > process.oomKills {
+		program.logger.Printf("process %d was killed by the OOM killer, memory_max %s", process.pid, program.cfg.MemoryMax)
+	}
+}
@@ -0,0 +1,110 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_ParseCgroupLimits(t *testing.T) {
+	for s, want := range map[string]string{
+		"":     "max",
+		"max":  "max",
+		"1024": "1024",
+		"512M": "536870912",
+		"2g":   "2147483648",
+	} {
+		if got, err := parseMemorySize(s); err != nil || got != want {
+			t.Errorf("memory %q: got %q %v, want %q", s, got, err, want)
+		}
+	}
+	for s, want := range map[string]string{
+		"":             "max",
+		"50%":          "50000 100000",
+		"150%":         "150000 100000",
+		"20000 100000": "20000 100000",
+		"max 100000":   "max 100000",
+	} {
+		if got, err := parseCPUMax(s); err != nil || got != want {
+			t.Errorf("cpu %q: got %q %v, want %q", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"abc", "-1%", "1 2 3", "x 100000"} {
+		if _, err := parseCPUMax(s); err == nil {
+			t.Errorf("cpu %q: expect error", s)
+		}
+	}
+	if _, err := parseMemorySize("12X"); err == nil {
+		t.Error("expect error for invalid memory size")
+	}
+	if err := (&ProgramConfig{CPUWeight: 20000}).checkCgroup(); err == nil {
+		t.Error("expect error for cpu_weight")
+	}
+}
+
+// 使用临时目录模拟cgroupfs，检查限制的写入以及进程加入cgroup
+func Test_Cgroup(t *testing.T) {
+	root, err := ioutil.TempDir("", "supergo-cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := SetCgroupRoot(filepath.Join(root, "none")); err == nil {
+		t.Fatal("expect error for missing cgroup root")
+	}
+	ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644)
+	if err := SetCgroupRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCgroupRoot("")
+
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1"}
+	cfg.MemoryMax = "64M"
+	cfg.CPUMax = "50%"
+	cfg.PidsMax = 16
+	if err := cfg.check(); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := NewProgram("cgroup", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer prog.StopProcess()
+
+	var pid string
+	waitFor(t, time.Second*5, func() bool {
+		pid = strconv.Itoa(prog.Status().Pid)
+		return pid != "0"
+	}, "process did not start")
+	dir := filepath.Join(root, "cgroup")
+	for file, want := range map[string]string{
+		"memory.max": "67108864",
+		"cpu.max":    "50000 100000",
+		"cpu.weight": "100",
+		"pids.max":   "16",
+	} {
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, file)); string(data) != want {
+			t.Errorf("%s: got %q, want %q", file, data, want)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
+		return string(data) == pid
+	}, "process did not join cgroup")
+
+	ioutil.WriteFile(filepath.Join(dir, "memory.current"), []byte("1048576\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 2000\nuser_usec 1500\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\noom 1\noom_kill 1\n"), 0644)
+	stats := prog.Status().Cgroup
+	if stats == nil || *stats != (CgroupStats{MemoryCurrent: 1048576, CPUUsageUsec: 2000, OOMKills: 1}) {
+		t.Fatalf("got cgroup stats %+v", stats)
+	}
+}
@@ -0,0 +1,214 @@
+package supervisord
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// shell模式下执行指令的shell
+const defaultShell = "/bin/sh"
+
+// 按照POSIX shell的规则将指令拆分为参数，支持单双引号、反斜杠转义以及$VAR和${VAR}，
+// 变量展开之后不会再被拆分，也不支持管道、重定向等shell语法
+func splitCommand(cmdline string, getenv func(string) string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	runes := []rune(cmdline)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			if i+1 >= len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			// 反斜杠加换行表示续行
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			i = end
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				switch runes[i] {
+				case '\\':
+					// 双引号中反斜杠只转义这几个字符
+					if i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+						i++
+						if runes[i] != '\n' {
+							word.WriteRune(runes[i])
+						}
+					} else {
+						word.WriteRune('\\')
+					}
+				case '$':
+					n, err := expandVar(runes, i, getenv, &word)
+					if err != nil {
+						return nil, err
+					}
+					i += n - 1
+				default:
+					word.WriteRune(runes[i])
+				}
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inWord = true
+		case c == '$':
+			n, err := expandVar(runes, i, getenv, &word)
+			if err != nil {
+				return nil, err
+			}
+			i += n - 1
+			inWord = true
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// 展开runes[i]处开始的变量，返回消耗的字符数，$后面不是变量名时原样保留
+func expandVar(runes []rune, i int, getenv func(string) string, word *strings.Builder) (int, error) {
+	if i+1 < len(runes) && runes[i+1] == '{' {
+		end := indexRune(runes, i+2, '}')
+		if end < 0 {
+			return 0, errors.New("unterminated ${")
+		}
+		name := string(runes[i+2 : end])
+		if !isVarName(name) {
+			return 0, fmt.Errorf("invalid variable name %q", name)
+		}
+		word.WriteString(getenv(name))
+		return end - i + 1, nil
+	}
+	j := i + 1
+	for j < len(runes) && isVarRune(runes[j], j == i+1) {
+		j++
+	}
+	if j == i+1 {
+		word.WriteRune('$')
+		return 1, nil
+	}
+	word.WriteString(getenv(string(runes[i+1 : j])))
+	return j - i, nil
+}
+
+func isVarRune(r rune, first bool) bool {
+	if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+		return true
+	}
+	return !first && r >= '0' && r <= '9'
+}
+
+func isVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isVarRune(r, i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// 解析进程的指令，返回可执行文件的路径和完整的参数，env为进程的环境变量
+func (cfg *ProgramConfig) commandArgs(env []string) (string, []string, error) {
+	if cfg.Shell {
+		// $0为shell本身，args作为$1开始的位置参数传给指令
+		args := append([]string{defaultShell, "-c", cfg.Command, defaultShell}, cfg.Args...)
+		return defaultShell, args, nil
+	}
+	getenv := envLookup(env)
+	args, err := splitCommand(cfg.Command, getenv)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse command: %s", err.Error())
+	}
+	if len(args) == 0 {
+		return "", nil, errors.New("command is empty")
+	}
+	path, err := lookPath(args[0], cfg.Directory, getenv("PATH"))
+	if err != nil {
+		return "", nil, err
+	}
+	return path, append(args, cfg.Args...), nil
+}
+
+// 包含/的路径相对于directory，否则在进程的PATH中查找，PATH中的相对路径也基于directory，返回绝对路径
+func lookPath(name, dir, pathEnv string) (string, error) {
+	if strings.Contains(name, "/") {
+		path, err := absPath(dir, name)
+		if err != nil {
+			return "", err
+		}
+		return exec.LookPath(path)
+	}
+	for _, p := range filepath.SplitList(pathEnv) {
+		if p == "" {
+			p = "."
+		}
+		path, err := absPath(dir, filepath.Join(p, name))
+		if err != nil {
+			return "", err
+		}
+		if path, err = exec.LookPath(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s: executable file not found in $PATH", name)
+}
+
+// 相对于directory的绝对路径，directory本身是相对路径时基于supergo的工作目录。
+// 不能把相对路径交给exec：不包含/时exec.LookPath会在supergo的PATH中查找，
+// 而exec.Cmd在切换到Dir之后还会再基于Dir解析一次
+func absPath(dir, name string) (string, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	return filepath.Abs(name)
+}
+
+func envLookup(env []string) func(string) string {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	return func(name string) string {
+		return vars[name]
+	}
+}
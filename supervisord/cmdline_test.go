@@ -0,0 +1,92 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_SplitCommand(t *testing.T) {
+	getenv := func(name string) string {
+		return map[string]string{"HOME": "/home/www", "NAME": "a b"}[name]
+	}
+	cases := []struct {
+		cmd  string
+		args []string
+		ok   bool
+	}{
+		{"./app  -a\t-b", []string{"./app", "-a", "-b"}, true},
+		{`app -msg "hello world" 'single  quoted'`, []string{"app", "-msg", "hello world", "single  quoted"}, true},
+		{`app a\ b \"c\"`, []string{"app", "a b", `"c"`}, true},
+		{`app "" ''`, []string{"app", "", ""}, true},
+		{`app --dir=$HOME/data "${NAME}" '$HOME'`, []string{"app", "--dir=/home/www/data", "a b", "$HOME"}, true},
+		{`app $NAME`, []string{"app", "a b"}, true},
+		{`app "a\$b\\c\d" $ 100$`, []string{"app", `a$b\c\d`, "$", "100$"}, true},
+		{"app \\\n  -a", []string{"app", "-a"}, true},
+		{"  ", nil, true},
+		{`app "unterminated`, nil, false},
+		{`app 'unterminated`, nil, false},
+		{`app \`, nil, false},
+		{`app ${HOME`, nil, false},
+		{`app ${1x}`, nil, false},
+	}
+	for _, c := range cases {
+		args, err := splitCommand(c.cmd, getenv)
+		if (err == nil) != c.ok {
+			t.Errorf("%q: unexpected err %v", c.cmd, err)
+			continue
+		}
+		if c.ok && !reflect.DeepEqual(args, c.args) {
+			t.Errorf("%q: got %q, want %q", c.cmd, args, c.args)
+		}
+	}
+}
+
+func Test_LookPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-cmdline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "bin"), 0755)
+	bin := filepath.Join(dir, "bin", "app")
+	if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if path, err := lookPath("./bin/app", dir, ""); err != nil || path != bin {
+		t.Errorf("relative path: got %q, %v", path, err)
+	}
+	if path, err := lookPath("app", dir, "/nonexistent:bin"); err != nil || path != bin {
+		t.Errorf("relative PATH: got %q, %v", path, err)
+	}
+	if path, err := lookPath("sh", dir, os.Getenv("PATH")); err != nil || !filepath.IsAbs(path) {
+		t.Errorf("PATH: got %q, %v", path, err)
+	}
+	if _, err := lookPath("app", dir, "/nonexistent"); err == nil {
+		t.Error("expect not found")
+	}
+	// 空的PATH项是directory，不能再去supergo的PATH中查找
+	if path, err := lookPath("sh", "", ":"); err == nil {
+		t.Errorf("empty PATH entry: got %q", path)
+	}
+	// 相对路径的directory基于supergo的工作目录，返回的路径不会再被exec基于directory解析
+	wd, _ := os.Getwd()
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"./bin/app", "app"} {
+		if path, err := lookPath(name, rel, "bin"); err != nil || path != bin {
+			t.Errorf("relative directory %s: got %q, %v", name, path, err)
+		}
+	}
+
+	cfg := &ProgramConfig{Directory: dir, Command: "echo $0 $1", Shell: true, Args: []string{"x"}}
+	path, args, err := cfg.commandArgs(nil)
+	if err != nil || path != defaultShell || !reflect.DeepEqual(args, []string{defaultShell, "-c", "echo $0 $1", defaultShell, "x"}) {
+		t.Errorf("shell: got %q %q, %v", path, args, err)
+	}
+}
@@ -1,10 +1,14 @@
 package supervisord
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/BurntSushi/toml"
 )
@@ -13,20 +17,72 @@ type SupervisorConfig struct {
 	Include struct {
 		Files string `toml:"files"`
 	} `toml:"include"`
+	Supergo struct {
+		StateFile  string `toml:"state_file"`  // 记录进程的状态文件，用于supergo重新启动之后接管进程
+		CgroupRoot string `toml:"cgroup_root"` // 委托给supergo的cgroup v2目录，每个程序使用其中的一个子cgroup
+	} `toml:"supergo"`
 	ProgramConfigs map[string]*ProgramConfig `toml:"program"`
+	Groups         map[string]*GroupConfig   `toml:"group"`
 }
 
 type ProgramConfig struct {
-	Directory         string   `toml:"directory" json:"directory"`
-	Command           string   `toml:"command" json:"command"`
-	Args              []string `toml:"args" json:"args"`
-	AutoRestart       bool     `toml:"auto_restart" json:"auto_restart"`
-	StdoutLogFile     string   `toml:"stdout_logfile" json:"stdout_logfile"`
-	StderrLogFile     string   `toml:"stderr_logfile" json:"stderr_logfile"`
-	MaxRetry          int      `toml:"max_retry" json:"max_retry"`
-	ListenAddrs       []string `toml:"listen_addrs" json:"listen_addrs"`
-	StopTimeout       int      `toml:"stop_timeout" json:"stop_timeout"`
-	StopBeforeRestart bool     `toml:"stop_before_restart" json:"stop_before_restart"`
+	Directory           string            `toml:"directory" json:"directory"`
+	Command             string            `toml:"command" json:"command"`
+	Args                []string          `toml:"args" json:"args"`
+	AutoRestart         bool              `toml:"auto_restart" json:"auto_restart"`
+	Autostart           *bool             `toml:"autostart" json:"autostart"`
+	StdoutLogFile       string            `toml:"stdout_logfile" json:"stdout_logfile"`
+	StderrLogFile       string            `toml:"stderr_logfile" json:"stderr_logfile"`
+	LogfileMaxBytes     string            `toml:"logfile_max_bytes" json:"logfile_max_bytes"`
+	LogfileBackups      *int              `toml:"logfile_backups" json:"logfile_backups"`
+	LogfileCompress     bool              `toml:"logfile_compress" json:"logfile_compress"`
+	LogfileRotate       string            `toml:"logfile_rotate" json:"logfile_rotate"`
+	LogBufferSize       string            `toml:"log_buffer_size" json:"log_buffer_size"`
+	LogFormat           string            `toml:"log_format" json:"log_format"`
+	RedirectStderr      bool              `toml:"redirect_stderr" json:"redirect_stderr"`
+	MaxRetry            int               `toml:"max_retry" json:"max_retry"`
+	ListenAddrs         []string          `toml:"listen_addrs" json:"listen_addrs"`
+	StopTimeout         int               `toml:"stop_timeout" json:"stop_timeout"`
+	StopBeforeRestart   bool              `toml:"stop_before_restart" json:"stop_before_restart"`
+	StopSignal          string            `toml:"stop_signal" json:"stop_signal"`
+	StopSignals         []*StopStep       `toml:"stop_signals" json:"stop_signals"`
+	KillGroup           bool              `toml:"kill_group" json:"kill_group"`
+	ReadyTimeout        int               `toml:"ready_timeout" json:"ready_timeout"`
+	Notify              bool              `toml:"notify" json:"notify"`
+	WatchdogSec         int               `toml:"watchdog_sec" json:"watchdog_sec"`
+	StartSecs           int               `toml:"start_secs" json:"start_secs"`
+	BackoffInitial      int               `toml:"backoff_initial" json:"backoff_initial"`
+	BackoffMax          int               `toml:"backoff_max" json:"backoff_max"`
+	BackoffMultiplier   float64           `toml:"backoff_multiplier" json:"backoff_multiplier"`
+	User                string            `toml:"user" json:"user"`
+	Group               string            `toml:"group" json:"group"`
+	SupplementaryGroups []string          `toml:"supplementary_groups" json:"supplementary_groups"`
+	Umask               string            `toml:"umask" json:"umask"`
+	Environment         EnvMap            `toml:"environment" json:"environment"`
+	EnvFiles            []string          `toml:"env_files" json:"env_files"`
+	CleanEnv            bool              `toml:"clean_env" json:"clean_env"`
+	InheritEnv          []string          `toml:"inherit_env" json:"inherit_env"`
+	Shell               bool              `toml:"shell" json:"shell"`
+	Pdeathsig           string            `toml:"pdeathsig" json:"pdeathsig"`
+	Adopt               bool              `toml:"adopt" json:"adopt"`
+	Rlimits             map[string]Rlimit `toml:"rlimits" json:"rlimits"`
+	MemoryMax           string            `toml:"memory_max" json:"memory_max"`
+	CPUWeight           int               `toml:"cpu_weight" json:"cpu_weight"`
+	CPUMax              string            `toml:"cpu_max" json:"cpu_max"`
+	PidsMax             int               `toml:"pids_max" json:"pids_max"`
+	Nice                int               `toml:"nice" json:"nice"`
+	CPUAffinity         string            `toml:"cpu_affinity" json:"cpu_affinity"`
+	IOClass             string            `toml:"io_class" json:"io_class"`
+	IOPriority          int               `toml:"io_priority" json:"io_priority"`
+	OOMScoreAdj         int               `toml:"oom_score_adj" json:"oom_score_adj"`
+	NumProcs            int               `toml:"numprocs" json:"numprocs"`
+	Priority            int               `toml:"priority" json:"priority"`
+	DependsOn           []string          `toml:"depends_on" json:"depends_on"`
+
+	HealthCheck *HealthCheckConfig `toml:"health_check" json:"health_check"`
+
+	instanceOf string // numprocs展开的实例所属的程序
+	instance   int    // 实例的序号
 }
 
 func newSupervisordConfig() *SupervisorConfig {
@@ -49,19 +105,153 @@ func ParseConfigFile(filepath string) (*SupervisorConfig, error) {
 			continue
 		}
 		for name, c := range subCfg.ProgramConfigs {
-			if c.StopTimeout == 0 {
-				c.StopTimeout = 10
-			}
-			if c.MaxRetry == 0 {
-				c.MaxRetry = 3
-			}
 			cfg.ProgramConfigs[name] = c
 		}
+		for name, g := range subCfg.Groups {
+			if cfg.Groups == nil {
+				cfg.Groups = make(map[string]*GroupConfig)
+			}
+			cfg.Groups[name] = g
+		}
+	}
+	for name, c := range cfg.ProgramConfigs {
+		c.setDefaults()
+		if err := c.check(); err != nil {
+			return nil, fmt.Errorf("program %s: %s", name, err.Error())
+		}
+		// 接管的进程记录在状态文件中，没有状态文件时无法接管
+		if c.Adopt && cfg.Supergo.StateFile == "" {
+			return nil, fmt.Errorf("program %s: adopt requires [supergo] state_file", name)
+		}
+	}
+	cfg.ProgramConfigs = expandInstances(cfg.ProgramConfigs)
+	if err := checkGroups(cfg.Groups, cfg.ProgramConfigs); err != nil {
+		return nil, err
+	}
+	if _, err := startOrder(cfg.ProgramConfigs); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
 }
 
+func (cfg *ProgramConfig) setDefaults() {
+	if cfg.StopTimeout == 0 {
+		cfg.StopTimeout = 10
+	}
+	if cfg.MaxRetry == 0 {
+		cfg.MaxRetry = 3
+	}
+	if cfg.ReadyTimeout == 0 {
+		cfg.ReadyTimeout = 30
+	}
+	if cfg.StartSecs == 0 {
+		cfg.StartSecs = 1
+	}
+	if cfg.BackoffInitial == 0 {
+		cfg.BackoffInitial = 1
+	}
+	if cfg.BackoffMax == 0 {
+		cfg.BackoffMax = 60
+	}
+	if cfg.BackoffMultiplier == 0 {
+		cfg.BackoffMultiplier = 2
+	}
+	if cfg.StopSignal == "" {
+		cfg.StopSignal = "TERM"
+	}
+	if cfg.LogBufferSize == "" {
+		cfg.LogBufferSize = defaultLogBufferSize
+	}
+	if cfg.HealthCheck != nil {
+		cfg.HealthCheck.setDefaults()
+	}
+}
+
+// 检查配置是否合法
+func (cfg *ProgramConfig) check() error {
+	if strings.TrimSpace(cfg.Command) == "" {
+		return errors.New("command is empty")
+	}
+	if !cfg.Shell {
+		if _, err := splitCommand(cfg.Command, os.Getenv); err != nil {
+			return fmt.Errorf("parse command: %s", err.Error())
+		}
+	}
+	if _, err := cfg.stopSteps(); err != nil {
+		return err
+	}
+	if cfg.BackoffMultiplier < 1 {
+		return errors.New("backoff_multiplier must not be less than 1")
+	}
+	if cfg.BackoffMax < cfg.BackoffInitial {
+		return errors.New("backoff_max must not be less than backoff_initial")
+	}
+	// 启动和重启时超过ready_timeout没有就绪的进程都会被停止，start_secs不能达到ready_timeout
+	if cfg.StartSecs >= cfg.ReadyTimeout {
+		return errors.New("start_secs must be less than ready_timeout")
+	}
+	if cfg.WatchdogSec > 0 && !cfg.Notify {
+		return errors.New("watchdog_sec requires notify")
+	}
+	// 用户和组在配置加载时就解析，权限不足时尽早报错
+	if _, err := cfg.credential(); err != nil {
+		return err
+	}
+	if _, err := cfg.umask(); err != nil {
+		return err
+	}
+	if cfg.Pdeathsig != "" {
+		sig, err := parseSignal(cfg.Pdeathsig)
+		if err != nil {
+			return err
+		}
+		if err := setPdeathsig(&syscall.SysProcAttr{}, sig); err != nil {
+			return err
+		}
+		if cfg.Adopt {
+			return errors.New("pdeathsig and adopt can not be used together")
+		}
+	}
+	if cfg.Adopt {
+		if _, err := procStartTime(os.Getpid()); err != nil {
+			return err
+		}
+	}
+	if err := cfg.checkRlimits(); err != nil {
+		return err
+	}
+	if err := cfg.checkCgroup(); err != nil {
+		return err
+	}
+	if err := cfg.checkSched(); err != nil {
+		return err
+	}
+	if err := cfg.checkLogfile(); err != nil {
+		return err
+	}
+	if err := cfg.checkLogFormat(); err != nil {
+		return err
+	}
+	if cfg.NumProcs < 0 {
+		return errors.New("numprocs must not be negative")
+	}
+	if len(cfg.InheritEnv) != 0 && !cfg.CleanEnv {
+		return errors.New("inherit_env requires clean_env")
+	}
+	for _, pattern := range cfg.InheritEnv {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid inherit_env %q", pattern)
+		}
+	}
+	if cfg.HealthCheck != nil {
+		if err := cfg.HealthCheck.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func getConfigFiles(configPath string) []string {
 	var files []string
 
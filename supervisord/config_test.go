@@ -5,3 +5,16 @@ import "testing"
 func Test_GetConfigFile(t *testing.T) {
 	t.Log(getConfigFiles("../*.toml"))
 }
+
+// 启动时超过ready_timeout没有就绪的进程会被停止，start_secs达到ready_timeout的进程永远不会Running
+func Test_CheckStartSecs(t *testing.T) {
+	cfg := helperConfig()
+	cfg.StartSecs = cfg.ReadyTimeout
+	if err := cfg.check(); err == nil {
+		t.Fatal("start_secs equal to ready_timeout is accepted")
+	}
+	cfg.StartSecs = cfg.ReadyTimeout - 1
+	if err := cfg.check(); err != nil {
+		t.Fatal(err)
+	}
+}
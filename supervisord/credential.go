@@ -0,0 +1,114 @@
+package supervisord
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// 解析进程运行的用户和组，没有配置或者与supergo相同时返回nil
+func (cfg *ProgramConfig) credential() (*syscall.Credential, error) {
+	if cfg.User == "" && cfg.Group == "" && len(cfg.SupplementaryGroups) == 0 {
+		return nil, nil
+	}
+	uid, gid := os.Geteuid(), os.Getegid()
+	var groups []uint32
+	if cfg.User != "" {
+		u, err := lookupUser(cfg.User)
+		if err != nil {
+			return nil, err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+		// 没有配置附加组时，使用用户本身所属的组
+		if len(cfg.SupplementaryGroups) == 0 {
+			gids, err := u.GroupIds()
+			if err != nil {
+				return nil, fmt.Errorf("get groups of user %s: %s", cfg.User, err.Error())
+			}
+			for _, g := range gids {
+				id, _ := strconv.Atoi(g)
+				groups = append(groups, uint32(id))
+			}
+		}
+	}
+	if cfg.Group != "" {
+		g, err := lookupGroup(cfg.Group)
+		if err != nil {
+			return nil, err
+		}
+		gid = g
+	}
+	for _, name := range cfg.SupplementaryGroups {
+		g, err := lookupGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, uint32(g))
+	}
+
+	if os.Geteuid() != 0 {
+		// 非root用户只能以自己的身份运行进程
+		if uid != os.Geteuid() || gid != os.Getegid() || len(cfg.SupplementaryGroups) != 0 {
+			return nil, fmt.Errorf("supergo is running as uid %d, not permitted to run as user %q group %q", os.Geteuid(), cfg.User, cfg.Group)
+		}
+		return nil, nil
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}, nil
+}
+
+// 用户名或者uid
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup user %s: %s", name, err.Error())
+	}
+	return u, nil
+}
+
+// 组名或者gid
+func lookupGroup(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("lookup group %s: %s", name, err.Error())
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// 八进制的umask，例如022，没有配置时返回-1
+func (cfg *ProgramConfig) umask() (int, error) {
+	if cfg.Umask == "" {
+		return -1, nil
+	}
+	mask, err := strconv.ParseUint(cfg.Umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return 0, fmt.Errorf("invalid umask %q", cfg.Umask)
+	}
+	return int(mask), nil
+}
+
+// 打开日志文件，新创建的文件属于进程运行的用户
+func openLogFile(path string, cred *syscall.Credential) (*os.File, error) {
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if os.IsNotExist(statErr) && cred != nil {
+		if err := f.Chown(int(cred.Uid), int(cred.Gid)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,92 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_Umask(t *testing.T) {
+	cases := []struct {
+		umask string
+		mask  int
+		ok    bool
+	}{
+		{"", -1, true},
+		{"022", 022, true},
+		{"0077", 077, true},
+		{"0", 0, true},
+		{"089", 0, false},
+		{"1777", 0, false},
+	}
+	for _, c := range cases {
+		cfg := &ProgramConfig{Umask: c.umask}
+		mask, err := cfg.umask()
+		if (err == nil) != c.ok {
+			t.Errorf("umask %q: unexpected err %v", c.umask, err)
+			continue
+		}
+		if c.ok && mask != c.mask {
+			t.Errorf("umask %q: got %o, want %o", c.umask, mask, c.mask)
+		}
+	}
+}
+
+func Test_Credential(t *testing.T) {
+	cred, err := (&ProgramConfig{}).credential()
+	if err != nil || cred != nil {
+		t.Fatalf("empty config: got %v, %v", cred, err)
+	}
+	if _, err := (&ProgramConfig{User: "supergo-no-such-user"}).credential(); err == nil {
+		t.Fatal("expect error for unknown user")
+	}
+
+	cred, err = (&ProgramConfig{User: "0", Group: "0"}).credential()
+	if os.Geteuid() != 0 {
+		// 非root时不能切换到root
+		if err == nil {
+			t.Fatal("expect permission error")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Uid != 0 || cred.Gid != 0 {
+		t.Fatalf("got uid %d gid %d", cred.Uid, cred.Gid)
+	}
+}
+
+// umask在shim中设置，只对进程生效，supergo自己的umask不变
+func Test_UmaskShim(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "umask")
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "umask", helperPidEnv: out}
+	cfg.Umask = "077"
+	prog, err := NewProgram("umask", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := syscall.Umask(022)
+	defer syscall.Umask(old)
+	if err := prog.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer prog.StopProcess()
+
+	var data []byte
+	waitFor(t, time.Second*5, func() bool {
+		data, _ = ioutil.ReadFile(out)
+		return len(data) != 0
+	}, "helper did not write umask")
+	if string(data) != "77" {
+		t.Errorf("got umask %s, want 77", data)
+	}
+	if mask := syscall.Umask(022); mask != 022 {
+		t.Errorf("umask of supergo changed to %o", mask)
+	}
+}
@@ -0,0 +1,248 @@
+package supervisord
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 进程的环境变量，序列化时会隐藏看起来是密钥的值
+type EnvMap map[string]string
+
+func (m EnvMap) MarshalJSON() ([]byte, error) {
+	masked := make(map[string]string, len(m))
+	for k, v := range m {
+		masked[k] = maskEnv(k, v)
+	}
+	return json.Marshal(masked)
+}
+
+// 名称中包含这些词的环境变量被认为是密钥
+var secretEnvWords = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "PRIVATE", "AUTH"}
+
+func maskEnv(name, value string) string {
+	upper := strings.ToUpper(name)
+	for _, w := range secretEnvWords {
+		if strings.Contains(upper, w) && value != "" {
+			return "******"
+		}
+	}
+	return value
+}
+
+// 按顺序合并supergo继承的环境变量、env_files和environment，后面的覆盖前面的
+func (cfg *ProgramConfig) environ() ([]string, error) {
+	env := make(map[string]string)
+	var keys []string
+	set := func(k, v string) {
+		if _, ok := env[k]; !ok {
+			keys = append(keys, k)
+		}
+		env[k] = v
+	}
+
+	for _, kv := range os.Environ() {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		if cfg.CleanEnv && !cfg.inheritEnv(kv[:i]) {
+			continue
+		}
+		set(kv[:i], kv[i+1:])
+	}
+	for _, file := range cfg.EnvFiles {
+		if !filepath.IsAbs(file) && cfg.Directory != "" {
+			file = filepath.Join(cfg.Directory, file)
+		}
+		vars, err := parseEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vars {
+			if v.literal {
+				set(v.key, v.value)
+			} else {
+				set(v.key, expandEnv(v.value))
+			}
+		}
+	}
+	// map是无序的，排序之后结果才稳定
+	names := make([]string, 0, len(cfg.Environment))
+	for k := range cfg.Environment {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		set(k, expandEnv(cfg.Environment[k]))
+	}
+	if cfg.instanceOf != "" {
+		set(instanceEnv, strconv.Itoa(cfg.instance))
+	}
+
+	environ := make([]string, 0, len(keys))
+	for _, k := range keys {
+		environ = append(environ, k+"="+env[k])
+	}
+	return environ, nil
+}
+
+// 只展开${VAR}，$name、$$等原样保留，值中可以直接使用$
+func expandEnv(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		if name := s[i+2 : i+j]; isVarName(name) {
+			b.WriteString(os.Getenv(name))
+		} else {
+			b.WriteString(s[i : i+j+1])
+		}
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// clean_env时只继承inherit_env中的变量，支持*通配
+func (cfg *ProgramConfig) inheritEnv(name string) bool {
+	for _, pattern := range cfg.InheritEnv {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// env_file中的一个变量，literal为true时值中的变量已经处理过（单引号中的值不展开，双引号中的值在解析时展开），不再展开
+type envVar struct {
+	key     string
+	value   string
+	literal bool
+}
+
+// 解析dotenv格式的文件，返回按顺序的变量
+func parseEnvFile(file string) ([]envVar, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vars []envVar
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: invalid line", file, lineNo)
+		}
+		key := strings.TrimSpace(line[:i])
+		value, literal, err := parseEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, lineNo, err.Error())
+		}
+		vars = append(vars, envVar{key: key, value: value, literal: literal})
+	}
+	return vars, scanner.Err()
+}
+
+// 双引号中支持\n \t \" \\ \$转义，其他的反斜杠原样保留，没有转义的${VAR}在解析时展开；
+// 单引号中的内容原样保留；引号之后只能有空白或者#注释。没有引号时#之后为注释
+func parseEnvValue(value string) (v string, literal bool, err error) {
+	if value == "" {
+		return "", false, nil
+	}
+	var rest string
+	switch value[0] {
+	case '"':
+		var b, raw strings.Builder // raw为还没有展开变量的部分
+		end := -1
+		for i := 1; i < len(value) && end < 0; i++ {
+			c := value[i]
+			switch {
+			case c == '"':
+				end = i
+			case c == '\\' && i+1 < len(value) && strings.IndexByte(`nt"\$`, value[i+1]) >= 0:
+				i++
+				switch value[i] {
+				case 'n':
+					raw.WriteByte('\n')
+				case 't':
+					raw.WriteByte('\t')
+				case '$':
+					// 转义的$不展开
+					b.WriteString(expandEnv(raw.String()))
+					raw.Reset()
+					b.WriteByte('$')
+				default:
+					raw.WriteByte(value[i])
+				}
+			default:
+				raw.WriteByte(c)
+			}
+		}
+		if end < 0 {
+			return "", false, fmt.Errorf("unterminated quoted value")
+		}
+		b.WriteString(expandEnv(raw.String()))
+		v, rest = b.String(), value[end+1:]
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", false, fmt.Errorf("unterminated quoted value")
+		}
+		v, rest = value[1:end+1], value[end+2:]
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), false, nil
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return "", false, fmt.Errorf("unexpected %q after quoted value", rest)
+	}
+	return v, true, nil
+}
+
+// 进程实际使用的环境变量，没有运行时按照当前配置计算
+func (program *Program) Environ() (EnvMap, error) {
+	program.lock.Lock()
+	process := program.process
+	program.lock.Unlock()
+	var environ []string
+	if process != nil && process.env != nil {
+		environ = process.env
+	} else {
+		var err error
+		if environ, err = program.cfg.environ(); err != nil {
+			return nil, err
+		}
+	}
+	env := make(EnvMap, len(environ))
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env, nil
+}
@@ -0,0 +1,128 @@
+package supervisord
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_ParseEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, ".env")
+	content := `# comment
+A=1
+export B = two words # comment
+C="line\nbreak"
+D='${HOME} #raw'
+E=
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := parseEnvFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []envVar{{"A", "1", false}, {"B", "two words", false}, {"C", "line\nbreak", true}, {"D", "${HOME} #raw", true}, {"E", "", false}}
+	if !reflect.DeepEqual(vars, expect) {
+		t.Fatalf("got %+v, want %+v", vars, expect)
+	}
+
+	ioutil.WriteFile(file, []byte("NOVALUE\n"), 0644)
+	if _, err := parseEnvFile(file); err == nil {
+		t.Fatal("expect error for invalid line")
+	}
+}
+
+func Test_ParseEnvValue(t *testing.T) {
+	os.Setenv("SUPERGO_TEST_VAR", "v")
+	defer os.Unsetenv("SUPERGO_TEST_VAR")
+	for _, c := range []struct {
+		value   string
+		want    string
+		literal bool
+		ok      bool
+	}{
+		{`"a\$b"`, "a$b", true, true},
+		{`"C:\x"`, `C:\x`, true, true},
+		{`"x" # say "hi"`, "x", true, true},
+		{`"a\tb\"c\\d"`, "a\tb\"c\\d", true, true},
+		{`"${SUPERGO_TEST_VAR}/\${SUPERGO_TEST_VAR}"`, "v/${SUPERGO_TEST_VAR}", true, true},
+		{`"a" junk`, "", false, false},
+		{`"unterminated`, "", false, false},
+		{`'a' junk`, "", false, false},
+		{`'a\n' # comment`, `a\n`, true, true},
+		{`'unterminated`, "", false, false},
+		{`plain # comment`, "plain", false, true},
+	} {
+		v, literal, err := parseEnvValue(c.value)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected err %v", c.value, err)
+			continue
+		}
+		if c.ok && (v != c.want || literal != c.literal) {
+			t.Errorf("%s: got %q %v, want %q %v", c.value, v, literal, c.want, c.literal)
+		}
+	}
+}
+
+func Test_Environ(t *testing.T) {
+	os.Setenv("SUPERGO_TEST_KEEP", "keep")
+	os.Setenv("SUPERGO_TEST_DROP", "drop")
+	defer os.Unsetenv("SUPERGO_TEST_KEEP")
+	defer os.Unsetenv("SUPERGO_TEST_DROP")
+
+	dir, err := ioutil.TempDir("", "supergo-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "app.env"), []byte("FROM_FILE=${SUPERGO_TEST_KEEP}\nRAW='${SUPERGO_TEST_KEEP}'\nOVERRIDE=file\n"), 0644)
+
+	cfg := &ProgramConfig{
+		Directory:   dir,
+		CleanEnv:    true,
+		InheritEnv:  []string{"SUPERGO_TEST_K*"},
+		EnvFiles:    []string{"app.env"},
+		Environment: EnvMap{"OVERRIDE": "config", "EXPANDED": "${SUPERGO_TEST_DROP}!", "DOLLAR": "pa$$word"},
+	}
+	environ, err := cfg.environ()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"SUPERGO_TEST_KEEP=keep", "FROM_FILE=keep", "RAW=${SUPERGO_TEST_KEEP}", "OVERRIDE=config", "DOLLAR=pa$$word", "EXPANDED=drop!"}
+	if !reflect.DeepEqual(environ, expect) {
+		t.Fatalf("got %q, want %q", environ, expect)
+	}
+}
+
+func Test_ExpandEnv(t *testing.T) {
+	os.Setenv("SUPERGO_TEST_VAR", "v")
+	defer os.Unsetenv("SUPERGO_TEST_VAR")
+	for s, want := range map[string]string{
+		"pa$$word":                   "pa$$word",
+		"$SUPERGO_TEST_VAR $1":       "$SUPERGO_TEST_VAR $1",
+		"${SUPERGO_TEST_VAR}/data":   "v/data",
+		"a${SUPERGO_TEST_VAR}b${}c$": "avb${}c$",
+		"${1x} ${SUPERGO_TEST_VAR":   "${1x} ${SUPERGO_TEST_VAR",
+	} {
+		if got := expandEnv(s); got != want {
+			t.Errorf("%q: got %q, want %q", s, got, want)
+		}
+	}
+}
+
+func Test_MaskEnv(t *testing.T) {
+	data, _ := json.Marshal(EnvMap{"DB_PASSWORD": "123", "api_key": "abc", "PORT": "80", "TOKEN": ""})
+	expect := `{"DB_PASSWORD":"******","PORT":"80","TOKEN":"","api_key":"******"}`
+	if string(data) != expect {
+		t.Fatalf("got %s, want %s", data, expect)
+	}
+}
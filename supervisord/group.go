@@ -0,0 +1,157 @@
+package supervisord
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// 所有程序
+const targetAll = "all"
+
+// 程序组，可以通过"组名:*"对组中的所有程序执行命令
+type GroupConfig struct {
+	Programs []string `toml:"programs" json:"programs"`
+}
+
+// 对一个程序执行命令的结果
+type ProgramResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// 组中的程序必须存在，numprocs的程序使用展开之前的名字
+func checkGroups(groups map[string]*GroupConfig, cfgs map[string]*ProgramConfig) error {
+	names := make(map[string]bool)
+	for name, cfg := range cfgs {
+		names[name] = true
+		if cfg.instanceOf != "" {
+			names[cfg.instanceOf] = true
+		}
+	}
+	for group, g := range groups {
+		for _, name := range g.Programs {
+			if !names[name] {
+				return fmt.Errorf("group %s: program %s not found", group, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (supervisor *Supervisor) SetGroups(groups map[string]*GroupConfig) {
+	supervisor.lock.Lock()
+	defer supervisor.lock.Unlock()
+	supervisor.cfg.Groups = groups
+}
+
+// 命令的目标可以是：程序名、numprocs展开之前的程序名、"组名:*"、all，以及web-*这样的通配，
+// 返回的程序按名称排序，同一个程序的实例按序号排序
+func (supervisor *Supervisor) resolveTarget(target string) ([]*Program, error) {
+	if prog, err := supervisor.getProgram(target); err == nil {
+		return []*Program{prog}, nil
+	}
+	match := func(prog *Program) bool {
+		return prog.cfg.instanceOf == target
+	}
+	if target == targetAll {
+		match = func(*Program) bool { return true }
+	} else if strings.HasSuffix(target, ":*") && supervisor.hasGroup(strings.TrimSuffix(target, ":*")) {
+		members := make(map[string]bool)
+		supervisor.lock.RLock()
+		for _, name := range supervisor.cfg.Groups[strings.TrimSuffix(target, ":*")].Programs {
+			members[name] = true
+		}
+		supervisor.lock.RUnlock()
+		match = func(prog *Program) bool {
+			return members[prog.Name] || members[prog.cfg.instanceOf]
+		}
+	} else if strings.ContainsAny(target, "*?[") {
+		if _, err := path.Match(target, ""); err != nil {
+			return nil, fmt.Errorf("invalid target %q", target)
+		}
+		match = func(prog *Program) bool {
+			ok, _ := path.Match(target, prog.Name)
+			return ok
+		}
+	}
+	var progs []*Program
+	for _, prog := range supervisor.ListPrograms() {
+		if match(prog) {
+			progs = append(progs, prog)
+		}
+	}
+	if len(progs) == 0 {
+		return nil, ErrProgramNotFound
+	}
+	return progs, nil
+}
+
+func (supervisor *Supervisor) hasGroup(name string) bool {
+	supervisor.lock.RLock()
+	defer supervisor.lock.RUnlock()
+	_, ok := supervisor.cfg.Groups[name]
+	return ok
+}
+
+// 依次对每个程序执行命令，某个程序失败时继续执行其余的程序
+func eachProgram(progs []*Program, cmd func(*Program) error) ([]*ProgramResult, error) {
+	results := make([]*ProgramResult, 0, len(progs))
+	failed := 0
+	for _, prog := range progs {
+		result := &ProgramResult{Name: prog.Name}
+		if err := cmd(prog); err != nil {
+			result.Error = err.Error()
+			failed++
+		}
+		results = append(results, result)
+	}
+	return results, resultsError(results, failed)
+}
+
+func resultsError(results []*ProgramResult, failed int) error {
+	if failed == 0 {
+		return nil
+	}
+	if len(results) == 1 {
+		return fmt.Errorf("%s", results[0].Error)
+	}
+	return fmt.Errorf("%d of %d programs failed", failed, len(results))
+}
+
+func (supervisor *Supervisor) StartPrograms(target string) ([]*ProgramResult, error) {
+	progs, err := supervisor.resolveTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	setOperatorStopped(progs, false)
+	return eachProgram(progs, (*Program).StartProcess)
+}
+
+func (supervisor *Supervisor) StopPrograms(target string) ([]*ProgramResult, error) {
+	progs, err := supervisor.resolveTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	setOperatorStopped(progs, true)
+	return eachProgram(progs, (*Program).StopProcess)
+}
+
+// 依次重启每个程序，某个程序失败时继续重启其余的程序，
+// 同一个程序的多个实例一个就绪之后再重启下一个，任何时候都有实例在运行
+func (supervisor *Supervisor) RestartPrograms(target string) ([]*ProgramResult, error) {
+	progs, err := supervisor.resolveTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	setOperatorStopped(progs, false)
+	if len(progs) == 1 {
+		return eachProgram(progs, (*Program).RestartProess)
+	}
+	return eachProgram(progs, func(prog *Program) error {
+		if prog.cfg.instanceOf != "" {
+			return prog.RestartAndWait()
+		}
+		return prog.RestartProess()
+	})
+}
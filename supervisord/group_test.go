@@ -0,0 +1,94 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_ParseGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "supergo.toml")
+	ioutil.WriteFile(file, []byte(`
+[program.api]
+command = "./api"
+numprocs = 2
+[program.job]
+command = "./job"
+[group.web]
+programs = ["api", "job"]
+`), 0644)
+	cfg, err := ParseConfigFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := cfg.Groups["web"]; g == nil || !reflect.DeepEqual(g.Programs, []string{"api", "job"}) {
+		t.Fatalf("got group %+v", g)
+	}
+
+	ioutil.WriteFile(file, []byte(`
+[program.api]
+command = "./api"
+[group.web]
+programs = ["api", "worker"]
+`), 0644)
+	if _, err := ParseConfigFile(file); err == nil {
+		t.Fatal("expect error for unknown program in group")
+	}
+}
+
+func Test_ResolveTarget(t *testing.T) {
+	cfgs := expandInstances(map[string]*ProgramConfig{
+		"web-api": {Command: "./api", NumProcs: 2},
+		"web-job": {Command: "./job"},
+		"cron":    {Command: "./cron"},
+	})
+	supervisor := NewSupervisor(&SupervisorConfig{
+		ProgramConfigs: make(map[string]*ProgramConfig),
+		Groups:         map[string]*GroupConfig{"batch": {Programs: []string{"web-job", "cron"}}},
+	})
+	for name, cfg := range cfgs {
+		cfg.setDefaults()
+		if _, err := supervisor.AddProgram(name, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for target, want := range map[string][]string{
+		"cron":      {"cron"},
+		"web-api":   {"web-api:0", "web-api:1"},
+		"web-api:1": {"web-api:1"},
+		"batch:*":   {"cron", "web-job"},
+		"web-*":     {"web-api:0", "web-api:1", "web-job"},
+		"all":       {"cron", "web-api:0", "web-api:1", "web-job"},
+	} {
+		progs, err := supervisor.resolveTarget(target)
+		if err != nil {
+			t.Errorf("%s: %s", target, err.Error())
+			continue
+		}
+		var names []string
+		for _, prog := range progs {
+			names = append(names, prog.Name)
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: got %v, want %v", target, names, want)
+		}
+	}
+	for _, target := range []string{"api", "none:*", "x-*", "[", "web"} {
+		if _, err := supervisor.resolveTarget(target); err == nil {
+			t.Errorf("%s: expect error", target)
+		}
+	}
+
+	// 程序都没有启动，stop对每个程序都失败
+	results, err := supervisor.StopPrograms("batch:*")
+	if err == nil || len(results) != 2 || results[0].Name != "cron" || results[0].Error == "" {
+		t.Fatalf("got results %+v %v", results, err)
+	}
+}
@@ -0,0 +1,213 @@
+package supervisord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckExec = "exec"
+
+	HealthStateHealthy   = "Healthy"
+	HealthStateUnhealthy = "Unhealthy"
+)
+
+type HealthCheckConfig struct {
+	Type             string `toml:"type" json:"type"`                           // http、tcp或exec
+	URL              string `toml:"url" json:"url"`                             // http检查的地址
+	ExpectStatus     int    `toml:"expect_status" json:"expect_status"`         // http检查期望的状态码，默认为200
+	Address          string `toml:"address" json:"address"`                     // tcp检查连接的地址
+	Command          string `toml:"command" json:"command"`                     // exec检查执行的指令，退出码为0时认为健康
+	Interval         int    `toml:"interval" json:"interval"`                   // 检查的间隔秒数
+	Timeout          int    `toml:"timeout" json:"timeout"`                     // 每次检查的超时秒数
+	FailureThreshold int    `toml:"failure_threshold" json:"failure_threshold"` // 连续失败多少次之后认为不健康
+	RestartThreshold int    `toml:"restart_threshold" json:"restart_threshold"` // 连续失败多少次之后自动重启，为0时不重启
+}
+
+func (cfg *HealthCheckConfig) setDefaults() {
+	if cfg.ExpectStatus == 0 {
+		cfg.ExpectStatus = http.StatusOK
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 10
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 3
+	}
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = 3
+	}
+}
+
+func (cfg *HealthCheckConfig) check() error {
+	switch cfg.Type {
+	case HealthCheckHTTP:
+		if cfg.URL == "" {
+			return errors.New("health check url is empty")
+		}
+	case HealthCheckTCP:
+		if cfg.Address == "" {
+			return errors.New("health check address is empty")
+		}
+	case HealthCheckExec:
+		args, err := splitCommand(cfg.Command, os.Getenv)
+		if err != nil {
+			return fmt.Errorf("parse health check command: %s", err.Error())
+		}
+		if len(args) == 0 {
+			return errors.New("health check command is empty")
+		}
+	default:
+		return fmt.Errorf("invalid health check type %q", cfg.Type)
+	}
+	return nil
+}
+
+// 周期性的检查进程是否健康，直到进程退出
+func (program *Program) checkHealth(process *Process) {
+	cfg := program.cfg.HealthCheck
+	ticker := time.NewTicker(time.Second * time.Duration(cfg.Interval))
+	defer ticker.Stop()
+
+	healthy := false
+	failures := 0
+	for {
+		// 重启时新的进程就绪之前，检查的地址可能由老的进程响应，结果不能算作新进程的
+		program.lock.Lock()
+		standby := process.standby
+		program.lock.Unlock()
+		if standby {
+			select {
+			case <-process.stopChan:
+				return
+			case <-ticker.C:
+			}
+			continue
+		}
+		err := program.probe(cfg)
+		if err == nil {
+			failures = 0
+			if !healthy {
+				healthy = true
+				close(process.healthyChan)
+			}
+			program.setHealth(process, HealthStateHealthy)
+		} else {
+			failures++
+			program.logger.Printf("health check failed(%d): %s", failures, err.Error())
+			if failures >= cfg.FailureThreshold {
+				program.setHealth(process, HealthStateUnhealthy)
+			}
+			if cfg.RestartThreshold > 0 && failures >= cfg.RestartThreshold && program.restartUnhealthy(process, failures) {
+				failures = 0
+			}
+		}
+
+		select {
+		case <-process.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 只有当前运行的进程才会更新程序的健康状态，重启时新启动的进程不会覆盖老进程的状态
+func (program *Program) setHealth(process *Process, health string) {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	if process.standby || program.process != process {
+		return
+	}
+	program.status.Health = health
+}
+
+// 重启不健康的进程，只在Running时重启，Starting时由就绪和ready_timeout的流程处理。
+// 有其他命令正在执行时不重启，下一次检查失败时再尝试，重启完成之前检查也不会继续，不会堆积多次重启
+func (program *Program) restartUnhealthy(process *Process, failures int) bool {
+	if !program.cmdLock.TryLock() {
+		return false
+	}
+	defer program.cmdLock.Unlock()
+	program.lock.Lock()
+	running := program.status.State == ProcessStateRunning && program.process == process
+	program.lock.Unlock()
+	if !running {
+		return false
+	}
+	program.logger.Printf("unhealthy after %d checks, restart", failures)
+	if err := program.restart(); err != nil {
+		program.logger.Printf("restart unhealthy process: %s", err.Error())
+	}
+	return true
+}
+
+func (program *Program) probe(cfg *HealthCheckConfig) error {
+	timeout := time.Second * time.Duration(cfg.Timeout)
+	switch cfg.Type {
+	case HealthCheckHTTP:
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(cfg.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != cfg.ExpectStatus {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	case HealthCheckTCP:
+		conn, err := net.DialTimeout("tcp", cfg.Address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case HealthCheckExec:
+		// 与进程本身使用相同的用户、umask和环境变量，指令在进程的PATH中查找
+		cred, err := program.cfg.credential()
+		if err != nil {
+			return err
+		}
+		mask, err := program.cfg.umask()
+		if err != nil {
+			return err
+		}
+		env, err := program.cfg.environ()
+		if err != nil {
+			return err
+		}
+		getenv := envLookup(env)
+		args, err := splitCommand(cfg.Command, getenv)
+		if err != nil {
+			return err
+		}
+		path, err := lookPath(args[0], program.cfg.Directory, getenv("PATH"))
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, path, args[1:]...)
+		cmd.Dir = program.cfg.Directory
+		cmd.Env = env
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+		if program.cfg.needShim() {
+			if err := program.useShim(cmd, cred, mask, ""); err != nil {
+				return err
+			}
+		}
+		if err := startChild(cmd); err != nil {
+			return err
+		}
+		return waitChild(cmd)
+	}
+	return fmt.Errorf("invalid health check type %q", cfg.Type)
+}
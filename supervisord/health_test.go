@@ -0,0 +1,269 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 标记文件不存在时返回200，否则返回500，开启了notify时，监听成功之后发送READY=1，
+// 端口被占用时进程仍然运行，但不会就绪
+func healthHelper() {
+	marker := os.Getenv(helperPidEnv)
+	l, err := net.Listen("tcp", os.Getenv(helperAddrEnv))
+	if err != nil {
+		return
+	}
+	if path := os.Getenv(NotifySocketEnv); path != "" {
+		if conn, err := net.Dial("unixgram", path); err == nil {
+			conn.Write([]byte("READY=1"))
+			conn.Close()
+		}
+	}
+	http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := os.Stat(marker); err == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// 第一次检查成功之后Running，连续失败之后Unhealthy，达到restart_threshold时重启
+func Test_HealthCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "unhealthy")
+	addr := freeAddr(t)
+
+	cfg := helperConfig()
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "health", helperAddrEnv: addr, helperPidEnv: marker}
+	// 新老进程监听相同的端口，先停止老的进程
+	cfg.StopBeforeRestart = true
+	cfg.HealthCheck = &HealthCheckConfig{
+		Type:             HealthCheckHTTP,
+		URL:              "http://" + addr + "/health",
+		Interval:         1,
+		Timeout:          1,
+		FailureThreshold: 1,
+		RestartThreshold: 3,
+	}
+	cfg.HealthCheck.setDefaults()
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	program, err := supervisor.AddProgram("health", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		s := program.Status()
+		return s.State == ProcessStateRunning && s.Health == HealthStateHealthy
+	}, "not healthy")
+	pid := program.Status().Pid
+
+	ioutil.WriteFile(marker, nil, 0644)
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().Health == HealthStateUnhealthy
+	}, "not unhealthy")
+	if s := program.Status(); s.State != ProcessStateRunning || s.Pid != pid {
+		t.Fatalf("restarted before restart_threshold: %s with pid %d", s.State, s.Pid)
+	}
+	waitFor(t, time.Second*10, func() bool {
+		return program.Status().Pid != pid
+	}, "not restarted after restart_threshold")
+	os.Remove(marker)
+	waitFor(t, time.Second*10, func() bool {
+		s := program.Status()
+		return s.State == ProcessStateRunning && s.Health == HealthStateHealthy
+	}, "not healthy after restart")
+}
+
+// 同时开启notify时，READY=1和第一次检查成功都满足之后才Running
+func Test_HealthCheckWithNotify(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "unhealthy")
+	if err := ioutil.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	addr := freeAddr(t)
+	cfg := helperConfig()
+	cfg.Notify = true
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "health", helperAddrEnv: addr, helperPidEnv: marker}
+	cfg.HealthCheck = &HealthCheckConfig{Type: HealthCheckHTTP, URL: "http://" + addr + "/health", Interval: 1}
+	cfg.HealthCheck.setDefaults()
+	program, err := NewProgram("health-notify", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer program.StopProcess()
+
+	// 进程监听之后已经发送了READY=1，但检查一直失败
+	time.Sleep(time.Second * 2)
+	if s := program.Status().State; s != ProcessStateStarting {
+		t.Fatalf("got %s before the first healthy check", s)
+	}
+	os.Remove(marker)
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().State == ProcessStateRunning
+	}, "not running after healthy")
+}
+
+// 先启动后停止的方式重启时，检查的地址仍由老的进程响应，新的进程没有发送READY=1时重启失败，老的进程继续运行
+func Test_HealthCheckRestart(t *testing.T) {
+	addr := freeAddr(t)
+	cfg := helperConfig()
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "health", helperAddrEnv: addr}
+	cfg.ReadyTimeout = 2
+	cfg.HealthCheck = &HealthCheckConfig{Type: HealthCheckHTTP, URL: "http://" + addr + "/health", Interval: 1}
+	cfg.HealthCheck.setDefaults()
+	cfg.Notify = true
+	if err := cfg.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	program, err := supervisor.AddProgram("health", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().State == ProcessStateRunning
+	}, "not running")
+	pid := program.Status().Pid
+
+	// 新的进程无法监听端口，一直不会就绪
+	if err := program.RestartProess(); err != ErrProcessNotReady {
+		t.Fatalf("got %v, want ErrProcessNotReady", err)
+	}
+	if s := program.Status(); s.State != ProcessStateRestartFailed || s.Pid != pid || s.Health != HealthStateHealthy {
+		t.Fatalf("got %s %s with pid %d after restart failed", s.State, s.Health, s.Pid)
+	}
+	if err := syscall.Kill(pid, 0); err != nil {
+		t.Fatal("old process is stopped")
+	}
+}
+
+func Test_HealthProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	program, _ := NewProgram("probe", helperConfig())
+	for _, c := range []struct {
+		cfg HealthCheckConfig
+		ok  bool
+	}{
+		{HealthCheckConfig{Type: HealthCheckTCP, Address: l.Addr().String()}, true},
+		{HealthCheckConfig{Type: HealthCheckTCP, Address: freeAddr(t)}, false},
+		{HealthCheckConfig{Type: HealthCheckExec, Command: "true"}, true},
+		{HealthCheckConfig{Type: HealthCheckExec, Command: "false"}, false},
+	} {
+		cfg := c.cfg
+		cfg.setDefaults()
+		if err := program.probe(&cfg); (err == nil) != c.ok {
+			t.Errorf("%s %s%s: got %v", cfg.Type, cfg.Address, cfg.Command, err)
+		}
+	}
+}
+
+// exec检查以进程配置的用户和环境变量运行，指令在进程的PATH中查找
+func Test_HealthProbeCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("need root to run as another user")
+	}
+	u, err := lookupUser("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	// nobody需要能够执行目录中的脚本
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := os.Chmod(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := "#!/bin/sh\ntest \"$(id -u)\" = " + u.Uid + " && test \"$PROBE_ENV\" = yes\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "probe-check"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := helperConfig()
+	cfg.Environment = EnvMap{"PATH": dir + ":/usr/bin:/bin", "PROBE_ENV": "yes"}
+	health := &HealthCheckConfig{Type: HealthCheckExec, Command: "probe-check"}
+	health.setDefaults()
+	program, _ := NewProgram("probe", cfg)
+	// 以root运行时检查失败
+	if err := program.probe(health); err == nil {
+		t.Fatal("probe runs as supergo's user")
+	}
+	cfg.User = "nobody"
+	program, _ = NewProgram("probe", cfg)
+	if err := program.probe(health); err != nil {
+		t.Fatalf("probe as nobody: %v", err)
+	}
+}
+
+// 没有开启notify时，先启动后停止的方式重启以start_secs判断新的进程是否就绪，不使用老的进程响应的健康检查
+func Test_HealthCheckRestartWithoutNotify(t *testing.T) {
+	addr := freeAddr(t)
+	cfg := helperConfig()
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "health", helperAddrEnv: addr}
+	cfg.StartSecs = 1
+	cfg.HealthCheck = &HealthCheckConfig{Type: HealthCheckHTTP, URL: "http://" + addr + "/health", Interval: 1, FailureThreshold: 1}
+	cfg.HealthCheck.setDefaults()
+	if err := cfg.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	program, err := supervisor.AddProgram("health", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().State == ProcessStateRunning
+	}, "not running")
+	pid := program.Status().Pid
+
+	// 新的进程无法监听端口，但是运行了start_secs之后即认为就绪
+	if err := program.RestartProess(); err != nil {
+		t.Fatal(err)
+	}
+	if s := program.Status(); s.State != ProcessStateRunning || s.Pid == pid {
+		t.Fatalf("got %s with pid %d after restart", s.State, s.Pid)
+	}
+	waitFor(t, time.Second*5, processExited(pid), "old process is not stopped")
+	// 老的进程退出之后，检查的是新的进程
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().Health == HealthStateUnhealthy
+	}, "new process is not unhealthy")
+}
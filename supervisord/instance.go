@@ -0,0 +1,96 @@
+package supervisord
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 程序的实例序号通过该环境变量传递给进程，从0开始
+const instanceEnv = "SUPERGO_INSTANCE"
+
+// numprocs大于1时，程序展开为name:0到name:N-1多个实例，每个实例都是一个独立的程序，
+// 日志路径和指令中的%(process_num)d和%(program_name)s会被替换
+func expandInstances(cfgs map[string]*ProgramConfig) map[string]*ProgramConfig {
+	expanded := make(map[string]*ProgramConfig)
+	for name, cfg := range cfgs {
+		if cfg.NumProcs <= 1 {
+			expanded[name] = cfg.instanceConfig(name, 0)
+			continue
+		}
+		for i := 0; i < cfg.NumProcs; i++ {
+			c := cfg.instanceConfig(name, i)
+			c.instanceOf = name
+			expanded[instanceName(name, i)] = c
+		}
+	}
+	return expanded
+}
+
+func instanceName(name string, i int) string {
+	return name + ":" + strconv.Itoa(i)
+}
+
+func (cfg *ProgramConfig) instanceConfig(name string, i int) *ProgramConfig {
+	c := *cfg
+	c.instance = i
+	r := strings.NewReplacer("%(process_num)d", strconv.Itoa(i), "%(program_name)s", name)
+	c.Command = r.Replace(c.Command)
+	c.StdoutLogFile = r.Replace(c.StdoutLogFile)
+	c.StderrLogFile = r.Replace(c.StderrLogFile)
+	return &c
+}
+
+// 同一个程序的多个实例共享listener，所有实例都关闭之后才真正关闭
+type sharedListener struct {
+	files []*os.File
+	refs  int
+}
+
+var (
+	sharedLock      sync.Mutex
+	sharedListeners = make(map[string]*sharedListener)
+)
+
+// 监听的地址也作为key的一部分，reload修改了地址之后，新的实例不会使用老的listener
+func (program *Program) sharedListenerKey() string {
+	return program.cfg.instanceOf + "|" + strings.Join(program.cfg.ListenAddrs, ",")
+}
+
+// 使用其他实例已经创建的listener，没有时创建，调用时需要持有program.lock
+func (program *Program) acquireListener() error {
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	key := program.sharedListenerKey()
+	shared := sharedListeners[key]
+	if shared == nil {
+		if err := program.openListener(); err != nil {
+			return err
+		}
+		shared = &sharedListener{files: program.files}
+		sharedListeners[key] = shared
+	}
+	shared.refs++
+	program.files = shared.files
+	program.listenerInited = true
+	return nil
+}
+
+// 调用时需要持有program.lock
+func (program *Program) releaseListener() {
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	key := program.sharedListenerKey()
+	shared := sharedListeners[key]
+	if shared == nil {
+		return
+	}
+	shared.refs--
+	if shared.refs == 0 {
+		for _, f := range shared.files {
+			f.Close()
+		}
+		delete(sharedListeners, key)
+	}
+}
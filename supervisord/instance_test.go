@@ -0,0 +1,146 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_ExpandInstances(t *testing.T) {
+	cfgs := expandInstances(map[string]*ProgramConfig{
+		"web": {
+			Command:       "./web --port=80%(process_num)d",
+			StdoutLogFile: "logs/%(program_name)s-%(process_num)d.log",
+			NumProcs:      2,
+		},
+		"job": {Command: "./job", StdoutLogFile: "logs/%(program_name)s.log"},
+	})
+	if len(cfgs) != 3 {
+		t.Fatalf("got %d programs, want 3", len(cfgs))
+	}
+	web := cfgs["web:1"]
+	if web == nil || web.Command != "./web --port=801" || web.StdoutLogFile != "logs/web-1.log" {
+		t.Fatalf("got web:1 %+v", web)
+	}
+	if job := cfgs["job"]; job == nil || job.StdoutLogFile != "logs/job.log" {
+		t.Fatalf("got job %+v", job)
+	}
+	env, err := web.environ()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env[len(env)-1] != instanceEnv+"=1" {
+		t.Errorf("got env %v", env[len(env)-1])
+	}
+}
+
+// 多个实例共享listener，逐个重启，所有实例停止之后listener才关闭
+func Test_Instances(t *testing.T) {
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1"}
+	cfg.ListenAddrs = []string{"127.0.0.1:0"}
+	cfg.NumProcs = 2
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	for name, c := range expandInstances(map[string]*ProgramConfig{"web": cfg}) {
+		if _, err := supervisor.AddProgram(name, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer supervisor.Exit()
+
+	p0, p1 := supervisor.GetProgram("web:0"), supervisor.GetProgram("web:1")
+	if p0.files[0] != p1.files[0] {
+		t.Fatal("instances do not share listener")
+	}
+	if err := supervisor.StartProgram("web"); err != nil {
+		t.Fatal(err)
+	}
+	running := func() bool {
+		return p0.Status().State == ProcessStateRunning && p1.Status().State == ProcessStateRunning
+	}
+	waitFor(t, time.Second*5, running, "instances are not running")
+	pid0, pid1 := p0.Status().Pid, p1.Status().Pid
+
+	// 第二个实例重启时，第一个实例已经重启完成并处于Running
+	done := make(chan error, 1)
+	go func() { done <- supervisor.RestartProgram("web") }()
+	waitFor(t, time.Second*5, func() bool {
+		return p1.Status().State == ProcessStateStarting
+	}, "web:1 is not restarted")
+	if s := p0.Status(); s.State != ProcessStateRunning || s.Pid == pid0 {
+		t.Fatalf("web:0 is %s with pid %d while web:1 restarting", s.State, s.Pid)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !running() || p1.Status().Pid == pid1 {
+		t.Fatal("web:1 is not restarted")
+	}
+
+	f := p0.files[0]
+	supervisor.StopProgram("web:1")
+	if _, err := f.Stat(); err != nil {
+		t.Fatal("listener closed while web:0 is running")
+	}
+	supervisor.StopProgram("web:0")
+	if _, err := f.Stat(); err == nil {
+		t.Fatal("listener is not closed")
+	}
+}
+
+// 某个实例重启失败时继续重启其余的实例，结果按照实例的顺序
+func Test_RestartInstancesContinue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-instance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+	cfg := helperConfig()
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "marker", helperPidEnv: marker}
+	cfg.NumProcs = 3
+	cfg.ReadyTimeout = 3
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	for name, c := range expandInstances(map[string]*ProgramConfig{"web": cfg}) {
+		if _, err := supervisor.AddProgram(name, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer supervisor.Exit()
+	if err := supervisor.StartProgram("web"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		for _, name := range []string{"web:0", "web:1", "web:2"} {
+			if supervisor.GetProgram(name).Status().State != ProcessStateRunning {
+				return false
+			}
+		}
+		return true
+	}, "instances are not running")
+	pid2 := supervisor.GetProgram("web:2").Status().Pid
+
+	// web:1重启之后的新进程启动失败
+	ioutil.WriteFile(marker+".1", nil, 0644)
+	results, err := supervisor.RestartPrograms("web")
+	if err == nil {
+		t.Fatal("want error for web:1")
+	}
+	var names []string
+	for _, r := range results {
+		names = append(names, r.Name)
+	}
+	if want := []string{"web:0", "web:1", "web:2"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got results %v, want %v", names, want)
+	}
+	if results[0].Error != "" || results[1].Error == "" || results[2].Error != "" {
+		t.Fatalf("got results %+v %+v %+v", results[0], results[1], results[2])
+	}
+	if s := supervisor.GetProgram("web:2").Status(); s.State != ProcessStateRunning || s.Pid == pid2 {
+		t.Fatalf("web:2 is %s with pid %d after web:1 failed", s.State, s.Pid)
+	}
+}
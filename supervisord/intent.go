@@ -0,0 +1,38 @@
+package supervisord
+
+// 记录程序是否被手动停止，没有配置状态文件时只在内存中记录
+func (s *processStore) setStopped(name string, stopped bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped[name] == stopped {
+		return
+	}
+	if stopped {
+		s.stopped[name] = true
+	} else {
+		delete(s.stopped, name)
+	}
+	s.save()
+}
+
+func (s *processStore) isStopped(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stopped[name]
+}
+
+// 通过API或者supergoctl停止的程序，在再次手动启动之前，supergo重新启动或者更新配置时都不会启动
+func setOperatorStopped(progs []*Program, stopped bool) {
+	for _, prog := range progs {
+		processStates.setStopped(prog.Name, stopped)
+	}
+}
+
+// supergo启动或者新增程序时是否启动
+func (program *Program) shouldAutostart() bool {
+	return program.cfg.autostart() && !processStates.isStopped(program.Name)
+}
+
+func (cfg *ProgramConfig) autostart() bool {
+	return cfg.Autostart == nil || *cfg.Autostart
+}
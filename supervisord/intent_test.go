@@ -0,0 +1,78 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 手动停止的程序，重新读取状态文件以及更新配置之后仍然保持停止
+func Test_OperatorStopped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-intent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+	if err := SetStateFile(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	defer SetStateFile("")
+
+	off := false
+	cfgs := make(map[string]*ProgramConfig)
+	for _, name := range []string{"foo", "bar", "baz"} {
+		cfgs[name] = helperConfig()
+		cfgs[name].Environment = EnvMap{helperEnv: "1"}
+	}
+	cfgs["bar"].Autostart = &off
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	for name, cfg := range cfgs {
+		if _, err := supervisor.AddProgram(name, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer supervisor.Exit()
+
+	if err := supervisor.StartProgram("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := supervisor.StopProgram("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(stateFile); !strings.Contains(string(data), `"stopped":["foo"]`) {
+		t.Fatalf("got state file %s", data)
+	}
+	// 模拟supergo重新启动
+	if err := SetStateFile(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	supervisor.StartAll((*Program).StartProcess)
+	for name, want := range map[string]ProgramState{"foo": ProcessStateStopped, "bar": ProcessStateStopped, "baz": ProcessStateStarting} {
+		if s := supervisor.GetProgram(name).Status(); s.State != want {
+			t.Errorf("%s is %s, want %s", name, s.State, want)
+		}
+	}
+
+	cfg := *cfgs["foo"]
+	cfg.StartSecs = 2
+	if _, err := supervisor.UpdateProgram("foo", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if s := supervisor.GetProgram("foo").Status(); s.State != ProcessStateStopped {
+		t.Errorf("foo is %s after update", s.State)
+	}
+
+	if err := supervisor.StartProgram("foo"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		return supervisor.GetProgram("foo").Status().State == ProcessStateRunning
+	}, "foo is not running")
+	if data, _ := ioutil.ReadFile(stateFile); strings.Contains(string(data), "foo") {
+		t.Fatalf("got state file %s", data)
+	}
+}
@@ -0,0 +1,192 @@
+package supervisord
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"syscall"
+)
+
+// 进程的输出流
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// 默认在内存中保留的每个输出流的字节数
+const defaultLogBufferSize = "64K"
+
+// 读取的一段输出，Offset为Data开始的位置，Next为下一次读取时使用的offset
+type LogChunk struct {
+	Stream string `json:"stream"`
+	Offset int64  `json:"offset"`
+	Next   int64  `json:"next"`
+	Data   string `json:"data"`
+}
+
+// 保留最近size个字节的环形缓冲区，偏移为程序创建之后输出的总字节数，进程重启之后继续累加
+type ringBuffer struct {
+	lock    sync.Mutex
+	buf     []byte
+	end     int64         // 已经写入的总字节数
+	changed chan struct{} // 写入或者关闭时关闭并替换，用于等待新的输出
+	closed  bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, size), changed: make(chan struct{})}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := len(p)
+	// 超过缓冲区大小的部分会被覆盖，直接跳过
+	if len(p) > len(b.buf) {
+		b.end += int64(len(p) - len(b.buf))
+		p = p[len(p)-len(b.buf):]
+	}
+	for len(p) > 0 {
+		c := copy(b.buf[b.end%int64(len(b.buf)):], p)
+		p = p[c:]
+		b.end += int64(c)
+	}
+	// 关闭之后进程残留的输出仍然可以写入，但不再通知
+	if !b.closed {
+		close(b.changed)
+		b.changed = make(chan struct{})
+	}
+	return n, nil
+}
+
+// 读取offset之后的内容，offset早于缓冲区中最早的内容或者晚于最新的内容时，从最早的内容开始读取
+func (b *ringBuffer) read(offset int64) (data []byte, start int64, next int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	start = b.end - int64(len(b.buf))
+	if start < 0 {
+		start = 0
+	}
+	if offset < start || offset > b.end {
+		offset = start
+	}
+	data = make([]byte, 0, b.end-offset)
+	for pos := offset; pos < b.end; {
+		i := pos % int64(len(b.buf))
+		j := int64(len(b.buf))
+		if b.end-pos < j-i {
+			j = i + b.end - pos
+		}
+		data = append(data, b.buf[i:j]...)
+		pos += j - i
+	}
+	return data, offset, b.end
+}
+
+// 返回在有新的输出或者关闭时关闭的channel，已经关闭时返回false
+func (b *ringBuffer) watch() (<-chan struct{}, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.changed, !b.closed
+}
+
+func (b *ringBuffer) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.changed)
+	}
+}
+
+func (cfg *ProgramConfig) logBufferSize() int {
+	n, _ := parseByteSize(cfg.LogBufferSize)
+	return int(n)
+}
+
+// 程序的输出缓冲区，没有开启时返回nil
+func (program *Program) logBuffer(stream string) *ringBuffer {
+	size := program.cfg.logBufferSize()
+	if size == 0 {
+		return nil
+	}
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	if program.logBuffers == nil {
+		program.logBuffers = make(map[string]*ringBuffer)
+	}
+	b := program.logBuffers[stream]
+	if b == nil {
+		b = newRingBuffer(size)
+		program.logBuffers[stream] = b
+	}
+	return b
+}
+
+// 调用时需要持有program.lock
+func (program *Program) closeLogBuffers() {
+	for _, b := range program.logBuffers {
+		b.Close()
+	}
+}
+
+// 进程的输出写入日志文件以及缓冲区，都没有时丢弃，不能让进程因为管道写满而阻塞
+func (program *Program) outputWriter(stream string, path string, cred *syscall.Credential) io.Writer {
+	var writers teeWriter
+	if path != "" {
+		writers = append(writers, program.logWriter(path, cred))
+	}
+	if b := program.logBuffer(stream); b != nil {
+		writers = append(writers, b)
+	}
+	switch len(writers) {
+	case 0:
+		return ioutil.Discard
+	case 1:
+		return writers[0]
+	}
+	return writers
+}
+
+// 与io.MultiWriter不同，某个writer失败时仍然写入其余的writer
+type teeWriter []io.Writer
+
+func (t teeWriter) Write(p []byte) (int, error) {
+	for _, w := range t {
+		w.Write(p)
+	}
+	return len(p), nil
+}
+
+func checkStream(stream string) error {
+	if stream != streamStdout && stream != streamStderr {
+		return fmt.Errorf("unknown stream %q, should be stdout or stderr", stream)
+	}
+	return nil
+}
+
+func (supervisor *Supervisor) logBufferOf(name string, stream string) (*ringBuffer, error) {
+	if err := checkStream(stream); err != nil {
+		return nil, err
+	}
+	prog, err := supervisor.getProgram(name)
+	if err != nil {
+		return nil, err
+	}
+	b := prog.logBuffer(stream)
+	if b == nil {
+		return nil, ErrLogBufferDisabled
+	}
+	return b, nil
+}
+
+// 读取程序在内存中保留的输出，offset为负数时从最早的内容开始读取
+func (supervisor *Supervisor) ReadLog(name string, stream string, offset int64) (*LogChunk, error) {
+	b, err := supervisor.logBufferOf(name, stream)
+	if err != nil {
+		return nil, err
+	}
+	data, start, next := b.read(offset)
+	return &LogChunk{Stream: stream, Offset: start, Next: next, Data: string(data)}, nil
+}
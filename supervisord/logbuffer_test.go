@@ -0,0 +1,105 @@
+package supervisord
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func Test_RingBuffer(t *testing.T) {
+	b := newRingBuffer(8)
+	b.Write([]byte("hello "))
+	if data, start, next := b.read(-1); string(data) != "hello " || start != 0 || next != 6 {
+		t.Fatalf("got %q %d %d", data, start, next)
+	}
+	// 写满之后覆盖最早的内容
+	b.Write([]byte("world"))
+	if data, start, next := b.read(-1); string(data) != "lo world" || start != 3 || next != 11 {
+		t.Fatalf("got %q %d %d", data, start, next)
+	}
+	if data, _, _ := b.read(9); string(data) != "ld" {
+		t.Fatalf("got %q from offset 9", data)
+	}
+	if data, _, _ := b.read(11); len(data) != 0 {
+		t.Fatalf("got %q from offset 11", data)
+	}
+	// 超过缓冲区大小的写入只保留最后的部分
+	b.Write([]byte("0123456789"))
+	if data, start, next := b.read(0); string(data) != "23456789" || start != 13 || next != 21 {
+		t.Fatalf("got %q %d %d", data, start, next)
+	}
+}
+
+// 没有配置日志文件时，进程的输出也会保留在内存中
+func Test_LogBuffer(t *testing.T) {
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "output"}
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	program, err := supervisor.AddProgram("output", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer supervisor.Exit()
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		chunk, err := supervisor.ReadLog("output", streamStderr, -1)
+		return err == nil && chunk.Data == "hello stderr\n"
+	}, "stderr is not captured")
+	chunk, err := supervisor.ReadLog("output", streamStdout, -1)
+	if err != nil || chunk.Data != "hello stdout\n" || chunk.Next != int64(len(chunk.Data)) {
+		t.Fatalf("got %+v %v", chunk, err)
+	}
+	if _, err := supervisor.ReadLog("output", "stdin", -1); err == nil {
+		t.Fatal("want error for unknown stream")
+	}
+
+	disabled := helperConfig()
+	disabled.LogBufferSize = "0"
+	supervisor.AddProgram("disabled", disabled)
+	if _, err := supervisor.ReadLog("disabled", streamStdout, -1); err != ErrLogBufferDisabled {
+		t.Fatalf("got %v, want ErrLogBufferDisabled", err)
+	}
+}
+
+// follow时持续输出新的内容，缓冲区关闭（程序被删除）之后结束
+func Test_FollowLogs(t *testing.T) {
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	s := &APIServer{Supervisor: supervisor}
+	for name, want := range map[string]string{
+		"plain": "a\nb\n",
+		"sse":   "id: 8\ndata: a\ndata: b\ndata: \n\n",
+	} {
+		program, err := supervisor.AddProgram(name, helperConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := program.logBuffer(streamStdout)
+		b.Write([]byte("old\n"))
+
+		req := httptest.NewRequest("GET", "/logs/"+name+"?follow=1&offset=4", nil)
+		if name == "sse" {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			s.getLogs(w, req, httprouter.Params{{Key: "name", Value: name}})
+			close(done)
+		}()
+		b.Write([]byte("a\nb\n"))
+		program.Destory()
+		select {
+		case <-done:
+		case <-time.After(time.Second * 5):
+			t.Fatal("follow does not return after buffer closed")
+		}
+		if got := w.Body.String(); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
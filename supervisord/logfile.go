@@ -0,0 +1,375 @@
+package supervisord
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 日志文件默认保留的切割文件数
+const defaultLogBackups = 10
+
+// 按时间切割日志的周期，周期不同时切割
+var logRotateLayouts = map[string]string{
+	"hourly": "2006010215",
+	"daily":  "20060102",
+}
+
+// 解析字节数，支持K、M、G、T后缀，以及KB、MB等写法
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	unit := int64(1)
+	upper := strings.TrimSuffix(strings.ToUpper(s), "B")
+	if upper != "" {
+		switch upper[len(upper)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		case 'T':
+			unit = 1 << 40
+		}
+	}
+	if unit != 1 {
+		upper = upper[:len(upper)-1]
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+func (cfg *ProgramConfig) checkLogfile() error {
+	if cfg.LogfileMaxBytes != "" {
+		if _, err := parseByteSize(cfg.LogfileMaxBytes); err != nil {
+			return err
+		}
+	}
+	if _, ok := logRotateLayouts[cfg.LogfileRotate]; !ok && cfg.LogfileRotate != "" {
+		return fmt.Errorf("unknown logfile_rotate %q, should be hourly or daily", cfg.LogfileRotate)
+	}
+	if _, err := parseByteSize(cfg.LogBufferSize); err != nil {
+		return fmt.Errorf("invalid log_buffer_size %q", cfg.LogBufferSize)
+	}
+	if cfg.LogfileBackups != nil && *cfg.LogfileBackups < 0 {
+		return errors.New("logfile_backups must not be negative")
+	}
+	// 接管模式下进程直接写日志文件，supergo重新启动之后进程的输出不能经过supergo
+	if cfg.Adopt && (cfg.LogfileMaxBytes != "" || cfg.LogfileRotate != "") {
+		return errors.New("log rotation can not be used with adopt")
+	}
+	return nil
+}
+
+// 按大小或者时间切割的日志文件，切割之后原文件重命名为path.1，path.1重命名为path.2，依此类推，
+// 同一个程序的多个进程（如重启时的新老进程）写同一个logWriter。
+// 切割时原文件先重命名为临时的名字，由后台的goroutine依次完成重命名和压缩，压缩时不会阻塞进程的输出
+type logWriter struct {
+	lock     sync.Mutex
+	path     string
+	cred     *syscall.Credential // 新建的日志文件属于该用户
+	maxBytes int64
+	backups  int
+	compress bool
+	layout   string // 按时间切割时的周期格式，为空时不按时间切割
+
+	file   *os.File
+	size   int64
+	period string
+	seq    int  // 切割的次数，用于生成临时的文件名
+	closed bool // 关闭之后copyOutput可能仍在写入，不能再重新打开文件
+
+	rotateLock sync.Mutex
+	rotated    []string // 等待重命名为path.1的文件
+	shifting   bool     // 后台的goroutine是否在运行
+	rotateWg   sync.WaitGroup
+}
+
+func newLogWriter(path string, cfg *ProgramConfig, cred *syscall.Credential) *logWriter {
+	w := &logWriter{
+		path:     path,
+		cred:     cred,
+		backups:  defaultLogBackups,
+		compress: cfg.LogfileCompress,
+		layout:   logRotateLayouts[cfg.LogfileRotate],
+	}
+	if cfg.LogfileMaxBytes != "" {
+		w.maxBytes, _ = parseByteSize(cfg.LogfileMaxBytes)
+	}
+	// 配置为0时不保留切割的文件
+	if cfg.LogfileBackups != nil {
+		w.backups = *cfg.LogfileBackups
+	}
+	return w
+}
+
+// 写入失败时丢弃日志，不能阻塞进程的输出
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return len(p), nil
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return len(p), nil
+		}
+	}
+	if (w.layout != "" && time.Now().Format(w.layout) != w.period) ||
+		(w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes) {
+		if err := w.rotate(); err != nil {
+			return len(p), nil
+		}
+	}
+	// 磁盘已满等错误同样丢弃，返回错误会导致copyOutput不再读取管道，进程将因为SIGPIPE退出
+	n, _ := w.file.Write(p)
+	w.size += int64(n)
+	return len(p), nil
+}
+
+// 调用时需要持有w.lock
+func (w *logWriter) open() error {
+	f, err := openLogFile(w.path, w.cred)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.size = 0
+	w.period = time.Now().Format(w.layout)
+	if info, err := f.Stat(); err == nil {
+		w.size = info.Size()
+		// 上一个周期留下来的文件，下一次写入时切割
+		if w.size > 0 {
+			w.period = info.ModTime().Format(w.layout)
+		}
+	}
+	return nil
+}
+
+func (w *logWriter) backupName(i int) string {
+	return w.path + "." + strconv.Itoa(i)
+}
+
+// 调用时需要持有w.lock
+func (w *logWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+	w.seq++
+	name := w.path + ".rotating-" + strconv.Itoa(w.seq)
+	err := os.Rename(w.path, name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		w.rotateLock.Lock()
+		w.rotated = append(w.rotated, name)
+		w.rotateWg.Add(1)
+		if !w.shifting {
+			w.shifting = true
+			go w.shiftBackups()
+		}
+		w.rotateLock.Unlock()
+	}
+	return w.open()
+}
+
+// 依次将切割出的文件重命名为path.1并压缩，之前的path.1重命名为path.2，依此类推
+func (w *logWriter) shiftBackups() {
+	for {
+		w.rotateLock.Lock()
+		if len(w.rotated) == 0 {
+			w.shifting = false
+			w.rotateLock.Unlock()
+			return
+		}
+		name := w.rotated[0]
+		w.rotated = w.rotated[1:]
+		w.rotateLock.Unlock()
+
+		if w.backups == 0 {
+			os.Remove(name)
+			w.rotateWg.Done()
+			continue
+		}
+		for _, ext := range []string{"", ".gz"} {
+			os.Remove(w.backupName(w.backups) + ext)
+			for i := w.backups - 1; i >= 1; i-- {
+				os.Rename(w.backupName(i)+ext, w.backupName(i+1)+ext)
+			}
+		}
+		if os.Rename(name, w.backupName(1)) == nil && w.compress {
+			compressFile(w.backupName(1))
+		}
+		w.rotateWg.Done()
+	}
+}
+
+// 压缩成功之后删除原文件
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if info, err := src.Stat(); err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			dst.Chown(int(stat.Uid), int(stat.Gid))
+		}
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// 关闭日志文件，下一次写入时重新打开，日志文件被外部的工具（如logrotate）移走之后使用
+func (w *logWriter) Reopen() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+}
+
+// 关闭之后的写入被丢弃
+func (w *logWriter) Close() {
+	w.lock.Lock()
+	w.closed = true
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	w.lock.Unlock()
+	w.rotateWg.Wait()
+}
+
+// 同一个路径的日志文件只有一个logWriter，numprocs的多个实例配置了相同的日志文件时不会各自切割
+type sharedLogWriter struct {
+	w    *logWriter
+	refs int
+}
+
+var (
+	logWritersLock   sync.Mutex
+	sharedLogWriters = make(map[string]*sharedLogWriter)
+)
+
+// 程序的日志文件，stdout和stderr配置为同一个文件时共用一个logWriter，
+// 其他程序已经在使用该文件时共用其他程序的logWriter，切割的配置以最先打开的程序为准
+func (program *Program) logWriter(path string, cred *syscall.Credential) *logWriter {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	if program.logWriters == nil {
+		program.logWriters = make(map[string]*logWriter)
+	}
+	w := program.logWriters[path]
+	if w == nil {
+		logWritersLock.Lock()
+		shared := sharedLogWriters[path]
+		if shared == nil {
+			shared = &sharedLogWriter{w: newLogWriter(path, program.cfg, cred)}
+			sharedLogWriters[path] = shared
+		}
+		shared.refs++
+		logWritersLock.Unlock()
+		w = shared.w
+		program.logWriters[path] = w
+	}
+	return w
+}
+
+// 创建管道，进程的输出经过supergo写入日志文件以及内存中的缓冲区，返回的读端由copyOutput读取
+func (program *Program) outputPipe(path string, cred *syscall.Credential) (r *os.File, w *os.File, err error) {
+	// 接管模式下supergo重新启动之后管道将失效，进程直接写日志文件
+	if program.cfg.Adopt {
+		if path == "" {
+			return nil, nil, nil
+		}
+		f, err := openLogFile(path, cred)
+		return nil, f, err
+	}
+	if path == "" && program.cfg.logBufferSize() == 0 {
+		return nil, nil, nil
+	}
+	return os.Pipe()
+}
+
+// 读取进程的输出直到所有的写端都已经关闭，进程fork出的子进程也可能持有写端
+func copyOutput(r *os.File, w io.Writer) {
+	io.Copy(w, r)
+	if f, ok := w.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+	r.Close()
+}
+
+// 启动读取进程输出的goroutine
+func (program *Program) copyOutputs(process *Process, cred *syscall.Credential) {
+	if process.stdoutPipe != nil {
+		go copyOutput(process.stdoutPipe, program.streamWriter(process, streamStdout, cred))
+	}
+	if process.stderrPipe != nil {
+		go copyOutput(process.stderrPipe, program.streamWriter(process, streamStderr, cred))
+	}
+}
+
+// 重新打开所有的日志文件
+func (program *Program) ReopenLogs() {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	for _, w := range program.logWriters {
+		w.Reopen()
+	}
+}
+
+// 释放程序使用的日志文件，返回已经没有程序使用的logWriter，调用时需要持有program.lock。
+// Close需要等待后台的切割完成，由调用者释放program.lock之后再调用
+func (program *Program) releaseLogs() []*logWriter {
+	logWritersLock.Lock()
+	defer logWritersLock.Unlock()
+	var closing []*logWriter
+	for path, w := range program.logWriters {
+		shared := sharedLogWriters[path]
+		if shared == nil || shared.w != w {
+			closing = append(closing, w)
+			continue
+		}
+		shared.refs--
+		if shared.refs == 0 {
+			delete(sharedLogWriters, path)
+			closing = append(closing, w)
+		}
+	}
+	program.logWriters = nil
+	return closing
+}
+
+func (supervisor *Supervisor) ReopenLogs() {
+	for _, prog := range supervisor.ListPrograms() {
+		prog.ReopenLogs()
+	}
+}
@@ -0,0 +1,193 @@
+package supervisord
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseByteSize(t *testing.T) {
+	for s, want := range map[string]int64{"100": 100, "10K": 10 << 10, "10KB": 10 << 10, "5mb": 5 << 20, "1G": 1 << 30} {
+		n, err := parseByteSize(s)
+		if err != nil || n != want {
+			t.Errorf("%s: got %d %v, want %d", s, n, err, want)
+		}
+	}
+	for _, s := range []string{"", "K", "-1", "10X"} {
+		if _, err := parseByteSize(s); err == nil {
+			t.Errorf("%s: want error", s)
+		}
+	}
+}
+
+// 超过大小之后切割，只保留logfile_backups个切割的文件，切割的文件压缩
+func Test_LogWriterRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	backups := 2
+	w := newLogWriter(path, &ProgramConfig{LogfileMaxBytes: "10", LogfileBackups: &backups, LogfileCompress: true}, nil)
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		w.Write([]byte(line))
+	}
+	w.Close()
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	readGzip := func(name string) string {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read(path); got != "line 4\n" {
+		t.Errorf("got %q in %s", got, path)
+	}
+	if got := readGzip(path + ".1.gz"); got != "line 3\n" {
+		t.Errorf("got %q in %s.1.gz", got, path)
+	}
+	if got := readGzip(path + ".2.gz"); got != "line 2\n" {
+		t.Errorf("got %q in %s.2.gz", got, path)
+	}
+	if names, _ := filepath.Glob(path + ".rotating-*"); len(names) != 0 {
+		t.Errorf("got temporary files %v", names)
+	}
+	for _, name := range []string{path + ".1", path + ".3.gz"} {
+		if _, err := os.Stat(name); err == nil {
+			t.Errorf("%s should not exist", name)
+		}
+	}
+}
+
+// logfile_backups为0时不保留切割的文件，关闭之后的写入不会重新打开文件
+func Test_LogWriterNoBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	backups := 0
+	w := newLogWriter(path, &ProgramConfig{LogfileMaxBytes: "10", LogfileBackups: &backups}, nil)
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		w.Write([]byte(line))
+	}
+	w.Close()
+	if names, _ := filepath.Glob(path + ".*"); len(names) != 0 {
+		t.Errorf("got backups %v", names)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "line 3\n" {
+		t.Errorf("got %q in %s", data, path)
+	}
+
+	os.Remove(path)
+	if n, err := w.Write([]byte("late\n")); n != 5 || err != nil {
+		t.Errorf("write after close: %d %v", n, err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("log file is reopened after close")
+	}
+}
+
+// 日志文件被移走之后，Reopen之前写入移走的文件，Reopen之后写入新的文件
+func Test_LogWriterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	w := newLogWriter(path, &ProgramConfig{}, nil)
+	defer w.Close()
+	w.Write([]byte("before\n"))
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("moved\n"))
+	w.Reopen()
+	w.Write([]byte("after\n"))
+
+	if data, _ := ioutil.ReadFile(path + ".old"); string(data) != "before\nmoved\n" {
+		t.Errorf("got %q in moved file", data)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("got %q in new file", data)
+	}
+}
+
+// 进程的输出经过管道写入日志文件，stdout和stderr可以是同一个文件
+func Test_ProcessOutput(t *testing.T) {
+	dir := t.TempDir()
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "output"}
+	cfg.StdoutLogFile = filepath.Join(dir, "out.log")
+	cfg.StderrLogFile = cfg.StdoutLogFile
+	program, err := NewProgram("output", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer program.Destory()
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer program.StopProcess()
+	waitFor(t, time.Second*5, func() bool {
+		data, _ := ioutil.ReadFile(cfg.StdoutLogFile)
+		return strings.Contains(string(data), "hello stdout") && strings.Contains(string(data), "hello stderr")
+	}, "output is not written to log file")
+}
+
+// 写入失败时丢弃日志，不返回错误，否则copyOutput将不再读取进程的输出
+func Test_LogWriterWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+	w := newLogWriter("/dev/full", &ProgramConfig{}, nil)
+	defer w.Close()
+	if n, err := w.Write([]byte("hello\n")); n != 6 || err != nil {
+		t.Fatalf("got %d %v, want 6 nil", n, err)
+	}
+}
+
+// 多个程序配置了相同的日志文件时共用一个logWriter，所有程序都Destory之后才关闭
+func Test_SharedLogWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	cfg := &ProgramConfig{Command: "true", LogfileMaxBytes: "10"}
+	p1, err := NewProgram("shared:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := NewProgram("shared:1", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w1 := p1.logWriter(path, nil)
+	w2 := p2.logWriter(path, nil)
+	if w1 != w2 {
+		t.Fatal("programs with the same log file should share the logWriter")
+	}
+	w1.Write([]byte("line 1\n"))
+	p1.Destory()
+	w2.Write([]byte("line 2\n"))
+	w2.lock.Lock()
+	opened := w2.file != nil
+	w2.lock.Unlock()
+	if !opened {
+		t.Error("logWriter closed while still used by another program")
+	}
+	p2.Destory()
+	if _, ok := sharedLogWriters[path]; ok {
+		t.Error("logWriter not released after all programs destroyed")
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "line 2\n" {
+		t.Errorf("got %q in %s", data, path)
+	}
+}
@@ -0,0 +1,142 @@
+package supervisord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 进程输出的格式
+const (
+	logFormatRaw    = "raw"    // 原样输出
+	logFormatPrefix = "prefix" // 每行加上时间、程序名和pid
+	logFormatJSON   = "json"   // 每行输出为一个JSON对象
+)
+
+// 超过该长度还没有换行时，作为一行输出
+const maxLogLine = 64 << 10
+
+const logTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// JSON格式的一行输出
+type logLine struct {
+	Ts      string `json:"ts"`
+	Program string `json:"program"`
+	Pid     int    `json:"pid"`
+	Stream  string `json:"stream"`
+	Line    string `json:"line"`
+}
+
+func (cfg *ProgramConfig) checkLogFormat() error {
+	switch cfg.LogFormat {
+	case "", logFormatRaw:
+	case logFormatPrefix, logFormatJSON:
+		// 接管模式下进程直接写日志文件
+		if cfg.Adopt {
+			return fmt.Errorf("log_format %s can not be used with adopt", cfg.LogFormat)
+		}
+	default:
+		return fmt.Errorf("unknown log_format %q, should be raw, prefix or json", cfg.LogFormat)
+	}
+	if cfg.RedirectStderr && cfg.StderrLogFile != "" {
+		return errors.New("stderr_logfile can not be used with redirect_stderr")
+	}
+	return nil
+}
+
+// 开启redirect_stderr时，stderr写入stdout的日志文件
+func (cfg *ProgramConfig) stderrLogFile() string {
+	if cfg.RedirectStderr {
+		return cfg.StdoutLogFile
+	}
+	return cfg.StderrLogFile
+}
+
+// 将进程的输出按行格式化之后写入w，每次写入的都是完整的行，
+// 新老进程或者stdout、stderr写同一个文件时，行与行之间不会交错
+type lineWriter struct {
+	w       io.Writer
+	format  string
+	program string
+	pid     int
+	stream  string
+	pending []byte // 还没有换行的输出
+}
+
+func newLineWriter(w io.Writer, format string, program string, pid int, stream string) *lineWriter {
+	return &lineWriter{w: w, format: format, program: program, pid: pid, stream: stream}
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.pending = append(lw.pending, p...)
+	var out []byte
+	for {
+		i := bytes.IndexByte(lw.pending, '\n')
+		if i < 0 {
+			if len(lw.pending) < maxLogLine {
+				break
+			}
+			i = maxLogLine
+		}
+		out = lw.appendLine(out, lw.pending[:i])
+		if i < len(lw.pending) && lw.pending[i] == '\n' {
+			i++
+		}
+		lw.pending = lw.pending[i:]
+	}
+	// 缓冲区全部输出之后释放，防止一直引用很长的行
+	if len(lw.pending) == 0 {
+		lw.pending = nil
+	}
+	if len(out) != 0 {
+		lw.w.Write(out)
+	}
+	return len(p), nil
+}
+
+// 输出最后没有换行的内容，进程的输出结束之后调用
+func (lw *lineWriter) Flush() {
+	if len(lw.pending) != 0 {
+		lw.w.Write(lw.appendLine(nil, lw.pending))
+		lw.pending = nil
+	}
+}
+
+func (lw *lineWriter) appendLine(out []byte, line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	ts := time.Now().Format(logTimeLayout)
+	if lw.format == logFormatJSON {
+		data, _ := json.Marshal(&logLine{Ts: ts, Program: lw.program, Pid: lw.pid, Stream: lw.stream, Line: string(line)})
+		return append(append(out, data...), '\n')
+	}
+	out = append(out, ts...)
+	out = append(out, ' ')
+	out = append(out, lw.program...)
+	out = append(out, '[')
+	out = strconv.AppendInt(out, int64(lw.pid), 10)
+	out = append(out, "] "...)
+	out = append(out, line...)
+	return append(out, '\n')
+}
+
+// 进程的一个输出流最终写入的writer，开启redirect_stderr时stderr写入stdout的日志文件和缓冲区
+func (program *Program) streamWriter(process *Process, stream string, cred *syscall.Credential) io.Writer {
+	target, path := stream, program.cfg.StdoutLogFile
+	if stream == streamStderr {
+		path = program.cfg.stderrLogFile()
+		if program.cfg.RedirectStderr {
+			target = streamStdout
+		}
+	}
+	w := program.outputWriter(target, path, cred)
+	switch program.cfg.LogFormat {
+	case logFormatPrefix, logFormatJSON:
+		return newLineWriter(w, program.cfg.LogFormat, program.Name, process.pid, stream)
+	}
+	return w
+}
@@ -0,0 +1,102 @@
+package supervisord
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LineWriter(t *testing.T) {
+	var buf bytes.Buffer
+	lw := newLineWriter(&buf, logFormatPrefix, "web:0", 42, streamStdout)
+	lw.Write([]byte("hello\nwor"))
+	lw.Write([]byte("ld\r\nlast"))
+	lw.Flush()
+	re := regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}\S* web:0\[42\] (.*)$`)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := []string{"hello", "world", "last"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %q", buf.String())
+	}
+	for i, line := range lines {
+		m := re.FindStringSubmatch(line)
+		if m == nil || m[1] != want[i] {
+			t.Errorf("got line %q, want %q", line, want[i])
+		}
+	}
+
+	buf.Reset()
+	lw = newLineWriter(&buf, logFormatJSON, "web", 42, streamStderr)
+	lw.Write([]byte("a \"quoted\" line\n"))
+	var l logLine
+	if err := json.Unmarshal(buf.Bytes(), &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Program != "web" || l.Pid != 42 || l.Stream != streamStderr || l.Line != `a "quoted" line` || l.Ts == "" {
+		t.Errorf("got %+v", l)
+	}
+}
+
+func Test_CheckLogFormat(t *testing.T) {
+	for _, cfg := range []*ProgramConfig{
+		{LogFormat: "xml"},
+		{LogFormat: logFormatJSON, Adopt: true},
+		{RedirectStderr: true, StdoutLogFile: "out.log", StderrLogFile: "err.log"},
+	} {
+		if err := cfg.checkLogFormat(); err == nil {
+			t.Errorf("%+v: want error", cfg)
+		}
+	}
+}
+
+// redirect_stderr时stdout和stderr写入同一个文件，JSON中区分两者
+func Test_RedirectStderr(t *testing.T) {
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "output"}
+	cfg.StdoutLogFile = filepath.Join(t.TempDir(), "out.log")
+	cfg.RedirectStderr = true
+	cfg.LogFormat = logFormatJSON
+	program, err := NewProgram("output", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer program.Destory()
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer program.StopProcess()
+
+	var lines []*logLine
+	waitFor(t, time.Second*5, func() bool {
+		data, _ := ioutil.ReadFile(cfg.StdoutLogFile)
+		lines = nil
+		for _, s := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			l := new(logLine)
+			if json.Unmarshal([]byte(s), l) == nil {
+				lines = append(lines, l)
+			}
+		}
+		return len(lines) == 2
+	}, "output is not written to log file")
+	pid := program.Status().Pid
+	streams := make(map[string]string)
+	for _, l := range lines {
+		if l.Pid != pid || l.Program != "output" {
+			t.Errorf("got %+v, want pid %d", l, pid)
+		}
+		streams[l.Stream] = l.Line
+	}
+	if streams[streamStdout] != "hello stdout" || streams[streamStderr] != "hello stderr" {
+		t.Errorf("got %v", streams)
+	}
+	// stderr也写入stdout的缓冲区
+	if data, _, _ := program.logBuffer(streamStdout).read(-1); !bytes.Contains(data, []byte("hello stderr")) {
+		t.Errorf("got %q in stdout buffer", data)
+	}
+}
@@ -15,7 +15,8 @@ type Supervisor struct {
 }
 
 var (
-	ErrProgramNotFound = errors.New("program not found")
+	ErrProgramNotFound   = errors.New("program not found")
+	ErrLogBufferDisabled = errors.New("log buffer is disabled")
 )
 
 func NewSupervisor(cfg *SupervisorConfig) *Supervisor {
@@ -36,69 +37,80 @@ func (supervisor *Supervisor) AddProgram(name string, progCfg *ProgramConfig) (p
 	return
 }
 
-func (supervisor *Supervisor) StartProgram(name string) error {
-	supervisor.lock.Lock()
-	defer supervisor.lock.Unlock()
+// supervisor.lock只保护programs，程序的命令在锁外执行，避免一个程序的重启阻塞其他的请求
+func (supervisor *Supervisor) getProgram(name string) (*Program, error) {
+	supervisor.lock.RLock()
+	defer supervisor.lock.RUnlock()
 	prog, ok := supervisor.porgrams[name]
 	if !ok {
-		return ErrProgramNotFound
+		return nil, ErrProgramNotFound
 	}
-	prog.StartProcess()
-	return nil
+	return prog, nil
+}
+
+func (supervisor *Supervisor) StartProgram(name string) error {
+	_, err := supervisor.StartPrograms(name)
+	return err
 }
 
 func (supervisor *Supervisor) StopProgram(name string) error {
-	supervisor.lock.Lock()
-	defer supervisor.lock.Unlock()
-	prog, ok := supervisor.porgrams[name]
-	if !ok {
-		return ErrProgramNotFound
-	}
-	prog.StopProcess()
-	return nil
+	_, err := supervisor.StopPrograms(name)
+	return err
 }
 
 func (supervisor *Supervisor) RestartProgram(name string) error {
-	supervisor.lock.Lock()
-	defer supervisor.lock.Unlock()
-	prog, ok := supervisor.porgrams[name]
-	if !ok {
-		return ErrProgramNotFound
-	}
-	prog.RestartProess()
-	return nil
+	_, err := supervisor.RestartPrograms(name)
+	return err
 }
 
 func (supervisor *Supervisor) DeleteProgram(name string) error {
 	supervisor.lock.Lock()
-	defer supervisor.lock.Unlock()
 	prog, ok := supervisor.porgrams[name]
 	delete(supervisor.porgrams, name)
 	delete(supervisor.cfg.ProgramConfigs, name)
+	supervisor.lock.Unlock()
 	if !ok {
 		return ErrProgramNotFound
 	}
 	prog.StopProcess()
 	prog.Destory()
+	processStates.setStopped(name, false)
 	return nil
 }
 
 func (supervisor *Supervisor) UpdateProgram(name string, progCfg *ProgramConfig) (prog *Program, err error) {
-	supervisor.lock.Lock()
-	defer supervisor.lock.Unlock()
-	prog, ok := supervisor.porgrams[name]
-	if !ok {
-		return nil, ErrProgramNotFound
+	prog, start, err := supervisor.replaceProgram(name, progCfg)
+	if err != nil {
+		return nil, err
+	}
+	if start {
+		supervisor.startPrograms([]*Program{prog}, (*Program).StartProcess)
+	}
+	return prog, nil
+}
+
+// 停止程序并以新的配置替换，返回新的程序以及是否需要启动。
+// 更新之前在运行的程序，即使没有开启autostart也继续运行，被手动停止的程序保持停止
+func (supervisor *Supervisor) replaceProgram(name string, progCfg *ProgramConfig) (*Program, bool, error) {
+	prog, err := supervisor.getProgram(name)
+	if err != nil {
+		return nil, false, err
+	}
+	running := true
+	switch prog.Status().State {
+	case ProcessStateStopped, ProcessStateExited, ProcessStateFatal:
+		running = false
 	}
 	prog.StopProcess()
 	prog.Destory()
 	newProg, err := NewProgram(name, progCfg)
 	if err != nil {
-		return
+		return nil, false, err
 	}
-	newProg.StartProcess()
+	supervisor.lock.Lock()
 	supervisor.porgrams[name] = newProg
-	return
+	supervisor.lock.Unlock()
+	return newProg, (running || progCfg.autostart()) && !processStates.isStopped(name), nil
 }
 
 func (supervisor *Supervisor) GetProgram(name string) *Program {
@@ -107,6 +119,22 @@ func (supervisor *Supervisor) GetProgram(name string) *Program {
 	return supervisor.porgrams[name]
 }
 
+func (supervisor *Supervisor) GetHistory(name string) ([]*StateTransition, error) {
+	prog, err := supervisor.getProgram(name)
+	if err != nil {
+		return nil, err
+	}
+	return prog.History(), nil
+}
+
+func (supervisor *Supervisor) GetEnv(name string) (EnvMap, error) {
+	prog, err := supervisor.getProgram(name)
+	if err != nil {
+		return nil, err
+	}
+	return prog.Environ()
+}
+
 func (supervisor *Supervisor) ListPrograms() []*Program {
 	supervisor.lock.RLock()
 	defer supervisor.lock.RUnlock()
@@ -114,32 +142,30 @@ func (supervisor *Supervisor) ListPrograms() []*Program {
 	for _, prog := range supervisor.porgrams {
 		progs = append(progs, prog)
 	}
+	// 同一个程序的实例按序号排序，name:10排在name:9之后
 	sort.Slice(progs, func(i, j int) bool {
+		a, b := progs[i].cfg, progs[j].cfg
+		if a.instanceOf != "" && a.instanceOf == b.instanceOf {
+			return a.instance < b.instance
+		}
 		return progs[i].Name < progs[j].Name
 	})
 	return progs
 }
 
 func (supervisor *Supervisor) Exit() {
-	supervisor.lock.Lock()
-	defer supervisor.lock.Unlock()
-	for _, program := range supervisor.porgrams {
+	for _, program := range supervisor.stopOrder() {
 		program.StopProcess()
 		program.Destory()
 	}
 }
 
 func (supervisor *Supervisor) GetStatus() []*ProgramStatus {
-	supervisor.lock.RLock()
-	defer supervisor.lock.RUnlock()
-	status := make([]*ProgramStatus, 0, len(supervisor.porgrams))
-	for _, prog := range supervisor.porgrams {
-		s := prog.Status()
-		status = append(status, s)
+	progs := supervisor.ListPrograms()
+	status := make([]*ProgramStatus, 0, len(progs))
+	for _, prog := range progs {
+		status = append(status, prog.Status())
 	}
-	sort.Slice(status, func(i, j int) bool {
-		return status[i].Name < status[j].Name
-	})
 	return status
 }
 
@@ -152,22 +178,36 @@ func (supervisor *Supervisor) Reload(cfgs map[string]*ProgramConfig) error {
 		}
 	}
 
+	var starts []*Program
 	for name, cfg := range inserts {
 		p, err := supervisor.AddProgram(name, cfg)
 		if err != nil {
 			log.Printf("add program %s error %s", name, err.Error())
 			continue
 		}
-		p.StartProcess()
+		starts = append(starts, autostartPrograms([]*Program{p})...)
 	}
 
-	for name, cfg := range updates {
-		_, err := supervisor.UpdateProgram(name, cfg)
+	// 依赖其他程序的程序先停止，更新之后与新增的程序一起按照依赖启动
+	for _, prog := range supervisor.stopOrder() {
+		cfg, ok := updates[prog.Name]
+		if !ok {
+			continue
+		}
+		p, start, err := supervisor.replaceProgram(prog.Name, cfg)
 		if err != nil {
-			log.Printf("update program %s error: %s", name, err.Error())
+			log.Printf("update program %s error: %s", prog.Name, err.Error())
+			continue
+		}
+		if start {
+			starts = append(starts, p)
 		}
 	}
+	supervisor.lock.Lock()
 	supervisor.cfg.ProgramConfigs = cfgs
+	supervisor.lock.Unlock()
+	// 等待依赖的程序就绪可能需要较长的时间，不阻塞API
+	go supervisor.startPrograms(starts, (*Program).StartProcess)
 	return nil
 }
 
@@ -176,6 +216,8 @@ func (supervisor *Supervisor) Diff(newCfgs map[string]*ProgramConfig) (
 	inserts map[string]*ProgramConfig,
 	deletes map[string]*ProgramConfig,
 	updates map[string]*ProgramConfig) {
+	supervisor.lock.RLock()
+	defer supervisor.lock.RUnlock()
 	oldCfgs := supervisor.cfg.ProgramConfigs
 	return diffConfigs(oldCfgs, newCfgs)
 }
@@ -0,0 +1,149 @@
+package supervisord
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 子进程通过该环境变量中的unix datagram socket通知supergo自己的状态，协议同systemd的sd_notify
+const NotifySocketEnv = "NOTIFY_SOCKET"
+
+var notifySeq int64
+
+// 优先在该目录中创建通知socket，不可用时（如没有/run的权限）在临时目录中新建一个目录
+const notifyRunDir = "/run/supergo"
+
+var notifyDir struct {
+	once sync.Once
+	path string
+	err  error
+}
+
+type notifySocket struct {
+	conn *net.UnixConn
+	path string
+}
+
+// 为每个进程创建单独的socket，重启时新老进程的通知不会混在一起
+func listenNotify(name string) (*notifySocket, error) {
+	dir, err := notifySocketDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d-%d.sock", name, os.Getpid(), atomic.AddInt64(&notifySeq, 1)))
+	return listenNotifyAt(path)
+}
+
+// 只有supergo的用户可以在其中创建文件的目录，其他用户不能抢先创建同名的socket或者替换为符号链接。
+// 子进程可能以其他用户运行，目录需要允许其他用户访问其中的socket
+func notifySocketDir() (string, error) {
+	notifyDir.once.Do(func() {
+		os.Mkdir(notifyRunDir, 0711)
+		if checkPrivateDir(notifyRunDir) == nil {
+			notifyDir.path = notifyRunDir
+			return
+		}
+		dir, err := ioutil.TempDir("", "supergo-")
+		if err == nil {
+			err = os.Chmod(dir, 0711)
+		}
+		notifyDir.path, notifyDir.err = dir, err
+	})
+	return notifyDir.path, notifyDir.err
+}
+
+// 目录属于supergo的用户，并且其他用户不能写入
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Geteuid() || info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is not a private directory", dir)
+	}
+	return nil
+}
+
+// 接管的进程使用上一次运行时的路径，同样需要检查所在的目录
+func listenNotifyAt(path string) (*notifySocket, error) {
+	if err := checkPrivateDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	os.Remove(path)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	if err := passCredentials(conn); err != nil {
+		conn.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &notifySocket{conn: conn, path: path}, nil
+}
+
+func (s *notifySocket) Close() error {
+	err := s.conn.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// 读取子进程发送的通知，直到socket被关闭，只接受进程本身及其进程组中的进程发送的通知
+func (program *Program) readNotify(process *Process) {
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(ucredSize))
+	ready := false
+	for {
+		n, oobn, _, _, err := process.notify.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return
+		}
+		if !notifySenderAllowed(oob[:oobn], process.pid) {
+			program.logger.Printf("ignore notification from other process")
+			continue
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "READY":
+				if kv[1] == "1" && !ready {
+					ready = true
+					close(process.notifyReadyChan)
+				}
+			case "STATUS":
+				program.setStatusText(process, kv[1])
+			case "STOPPING":
+				if kv[1] == "1" {
+					program.logger.Printf("process %d is stopping", process.pid)
+				}
+			case "WATCHDOG":
+				if kv[1] == "1" {
+					process.lastKeepalive.Store(time.Now().UnixNano())
+				}
+			}
+		}
+	}
+}
+
+// 与健康状态一样，只有当前运行或正在启动的进程才会更新程序的状态信息
+func (program *Program) setStatusText(process *Process, text string) {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	process.statusText = text
+	if process.standby || (program.process != nil && program.process != process) {
+		return
+	}
+	program.status.StatusText = text
+}
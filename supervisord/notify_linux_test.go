@@ -0,0 +1,37 @@
+package supervisord
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func Test_NotifySenderAllowed(t *testing.T) {
+	oob := func(pid int) []byte {
+		return syscall.UnixCredentials(&syscall.Ucred{Pid: int32(pid)})
+	}
+	// 已经退出并且被回收的进程，无法查询其进程组
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	exited := cmd.Process.Pid
+	self := os.Getpid()
+	pgid := syscall.Getpgrp()
+
+	for _, c := range []struct {
+		sender int
+		pid    int
+		ok     bool
+	}{
+		{self, self, true},
+		{self, pgid, true},
+		{exited, self, true},
+		{self, exited, false},
+	} {
+		if got := notifySenderAllowed(oob(c.sender), c.pid); got != c.ok {
+			t.Errorf("sender %d, pid %d: got %v, want %v", c.sender, c.pid, got, c.ok)
+		}
+	}
+}
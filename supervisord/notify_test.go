@@ -0,0 +1,135 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 写入自己的pid之后向NOTIFY_SOCKET发送helperNotifyEnv中的通知，收到SIGUSR1时发送READY=1，
+// 有WATCHDOG_USEC时持续发送心跳，收到SIGUSR2之后停止心跳，模拟挂起的进程
+func notifyHelper() {
+	if file := os.Getenv(helperPidEnv); file != "" {
+		ioutil.WriteFile(file, []byte(strconv.Itoa(os.Getpid())), 0644)
+	}
+	conn, err := net.Dial("unixgram", os.Getenv(NotifySocketEnv))
+	if err != nil {
+		os.Exit(2)
+	}
+	if msg := os.Getenv(helperNotifyEnv); msg != "" {
+		conn.Write([]byte(msg))
+	}
+	var keepalive <-chan time.Time
+	if usec, _ := strconv.Atoi(os.Getenv(WatchdogUsecEnv)); usec > 0 {
+		keepalive = time.NewTicker(time.Duration(usec) * time.Microsecond / 4).C
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+	for {
+		select {
+		case <-keepalive:
+			conn.Write([]byte("WATCHDOG=1"))
+		case sig := <-c:
+			switch sig {
+			case syscall.SIGUSR1:
+				conn.Write([]byte("READY=1"))
+			case syscall.SIGUSR2:
+				keepalive = nil
+			default:
+				os.Exit(0)
+			}
+		}
+	}
+}
+
+func notifyConfig(msg string) *ProgramConfig {
+	cfg := helperConfig()
+	cfg.Notify = true
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "notify", helperNotifyEnv: msg}
+	return cfg
+}
+
+// 进程发送READY=1之后才会Running，STATUS显示在状态中
+func Test_Notify(t *testing.T) {
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	program, err := supervisor.AddProgram("notify", notifyConfig("STATUS=warming up\nSTOPPING=0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().StatusText == "warming up"
+	}, "status text is not updated")
+	program.lock.Lock()
+	path := program.process.notify.path
+	program.lock.Unlock()
+	if err := checkPrivateDir(filepath.Dir(path)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他进程发送的通知被忽略
+	if runtime.GOOS == "linux" {
+		conn, err := net.Dial("unixgram", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("READY=1"))
+		conn.Close()
+	}
+	time.Sleep(time.Second * 2)
+	s := program.Status()
+	if s.State != ProcessStateStarting {
+		t.Fatalf("got %s before READY=1", s.State)
+	}
+	syscall.Kill(s.Pid, syscall.SIGUSR1)
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().State == ProcessStateRunning
+	}, "not running after READY=1")
+}
+
+// 进程组中短暂运行的进程（如systemd-notify）发送READY=1之后立即退出，通知仍然有效
+func Test_NotifyFromExitedChild(t *testing.T) {
+	cfg := notifyConfig("")
+	cfg.Environment[helperModeEnv] = "notify-child"
+	program, err := NewProgram("notify-child", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer program.StopProcess()
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().State == ProcessStateRunning
+	}, "not running after READY=1 from child")
+}
+
+// 其他用户可以写入的目录不能用来创建通知socket
+func Test_NotifyDir(t *testing.T) {
+	dir, err := notifySocketDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	shared, err := ioutil.TempDir("", "supergo-shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(shared)
+	os.Chmod(shared, 0777)
+	if _, err := listenNotifyAt(filepath.Join(shared, "test.sock")); err == nil {
+		t.Fatal("listen in a world-writable directory")
+	}
+}
@@ -0,0 +1,213 @@
+package supervisord
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 依赖的程序，numprocs的程序依赖于其所有实例
+func dependencies(name string, cfgs map[string]*ProgramConfig) ([]string, error) {
+	var deps []string
+	for _, dep := range cfgs[name].DependsOn {
+		if _, ok := cfgs[dep]; ok {
+			deps = append(deps, dep)
+			continue
+		}
+		found := false
+		for other, cfg := range cfgs {
+			if cfg.instanceOf == dep {
+				deps = append(deps, other)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("program %s: depends on unknown program %s", name, dep)
+		}
+	}
+	sort.Strings(deps)
+	return deps, nil
+}
+
+// 程序的启动顺序，被依赖的程序先启动，没有依赖关系的程序按照priority从小到大，priority相同时按名称排序，
+// 存在循环依赖时返回错误
+func startOrder(cfgs map[string]*ProgramConfig) ([]string, error) {
+	deps := make(map[string][]string)
+	dependents := make(map[string][]string)
+	pending := make(map[string]int)
+	for name := range cfgs {
+		d, err := dependencies(name, cfgs)
+		if err != nil {
+			return nil, err
+		}
+		deps[name] = d
+		pending[name] = len(d)
+		for _, dep := range d {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+	less := func(a, b string) bool {
+		if cfgs[a].Priority != cfgs[b].Priority {
+			return cfgs[a].Priority < cfgs[b].Priority
+		}
+		return a < b
+	}
+	var ready []string
+	for name, n := range pending {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+	order := make([]string, 0, len(cfgs))
+	for len(ready) != 0 {
+		sort.Slice(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, d := range dependents[name] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if len(order) != len(cfgs) {
+		return nil, fmt.Errorf("dependency cycle: %s", findCycle(deps, pending))
+	}
+	return order, nil
+}
+
+// 在还没有排序的程序中找出一个环，如a -> b -> a
+func findCycle(deps map[string][]string, pending map[string]int) string {
+	var start string
+	for name, n := range pending {
+		if n > 0 && (start == "" || name < start) {
+			start = name
+		}
+	}
+	// 未排序的程序都至少依赖一个未排序的程序，沿着依赖一直走下去必然会回到走过的程序
+	index := make(map[string]int)
+	var path []string
+	for name := start; ; {
+		if i, ok := index[name]; ok {
+			return strings.Join(append(path[i:], name), " -> ")
+		}
+		index[name] = len(path)
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if pending[dep] > 0 {
+				name = dep
+				break
+			}
+		}
+	}
+}
+
+// 启动所有的程序，按照priority从小到大分批启动，一批全部启动之后再启动下一批，同一批中的程序并发启动，
+// 每个程序在其依赖的程序Running之后启动，没有开启autostart以及被手动停止的程序不启动，
+// 依赖的程序启动失败或者超过其ready_timeout还没有Running时，不启动该程序
+func (supervisor *Supervisor) StartAll(launch func(*Program) error) {
+	supervisor.startPrograms(autostartPrograms(supervisor.ListPrograms()), launch)
+}
+
+// 开启了autostart并且没有被手动停止的程序
+func autostartPrograms(progs []*Program) []*Program {
+	var starts []*Program
+	for _, prog := range progs {
+		if prog.shouldAutostart() {
+			starts = append(starts, prog)
+		} else if prog.cfg.autostart() {
+			prog.logger.Printf("not started, stopped by operator")
+		}
+	}
+	return starts
+}
+
+// 启动targets并等待全部启动完成，依赖的程序不在targets中时，只等待其Running，已经停止的依赖不会被启动
+func (supervisor *Supervisor) startPrograms(targets []*Program, launch func(*Program) error) {
+	progs := make(map[string]*Program)
+	cfgs := make(map[string]*ProgramConfig)
+	for _, prog := range supervisor.ListPrograms() {
+		progs[prog.Name] = prog
+		cfgs[prog.Name] = prog.cfg
+	}
+	order, err := startOrder(cfgs)
+	if err != nil {
+		log.Printf("start programs: %s", err.Error())
+		return
+	}
+	// 程序启动（或者放弃启动）之后关闭，依赖它的程序在此之后才检查其状态
+	launched := make(map[string]chan struct{})
+	for _, prog := range targets {
+		launched[prog.Name] = make(chan struct{})
+	}
+	// 程序所在的批次不早于其依赖的程序，否则会等待还没有开始启动的批次
+	levels := make(map[string]int)
+	byLevel := make(map[int][]string)
+	for _, name := range order {
+		deps, _ := dependencies(name, cfgs)
+		level := cfgs[name].Priority
+		for _, dep := range deps {
+			if levels[dep] > level {
+				level = levels[dep]
+			}
+		}
+		levels[name] = level
+		if _, ok := launched[name]; ok {
+			byLevel[level] = append(byLevel[level], name)
+		}
+	}
+	var sorted []int
+	for level := range byLevel {
+		sorted = append(sorted, level)
+	}
+	sort.Ints(sorted)
+	for _, level := range sorted {
+		var wg sync.WaitGroup
+		for _, name := range byLevel[level] {
+			prog := progs[name]
+			done := launched[name]
+			deps, _ := dependencies(name, cfgs)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(done)
+				for _, dep := range deps {
+					if c, ok := launched[dep]; ok {
+						<-c
+					}
+					if err := progs[dep].waitRunning(); err != nil {
+						prog.logger.Printf("not started, dependency %s: %s", dep, err.Error())
+						return
+					}
+				}
+				if err := launch(prog); err != nil {
+					prog.logger.Printf("start error: %s", err.Error())
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// 按照启动相反的顺序停止程序，依赖其他程序的程序先停止
+func (supervisor *Supervisor) stopOrder() []*Program {
+	progs := supervisor.ListPrograms()
+	cfgs := make(map[string]*ProgramConfig)
+	index := make(map[string]*Program)
+	for _, prog := range progs {
+		cfgs[prog.Name] = prog.cfg
+		index[prog.Name] = prog
+	}
+	order, err := startOrder(cfgs)
+	if err != nil {
+		return progs
+	}
+	stops := make([]*Program, 0, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		stops = append(stops, index[order[i]])
+	}
+	return stops
+}
@@ -0,0 +1,206 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_StartOrder(t *testing.T) {
+	cfgs := expandInstances(map[string]*ProgramConfig{
+		"db":     {Priority: 10},
+		"cache":  {Priority: 5},
+		"api":    {DependsOn: []string{"db", "cache"}, NumProcs: 2},
+		"worker": {DependsOn: []string{"api"}},
+		"cron":   {Priority: 20},
+	})
+	order, err := startOrder(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cache", "db", "api:0", "api:1", "worker", "cron"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+
+	cfgs = map[string]*ProgramConfig{
+		"a": {DependsOn: []string{"b"}},
+		"b": {DependsOn: []string{"c"}},
+		"c": {DependsOn: []string{"b"}},
+	}
+	if _, err := startOrder(cfgs); err == nil || !strings.HasSuffix(err.Error(), "b -> c -> b") {
+		t.Fatalf("got %v, want cycle b -> c -> b", err)
+	}
+	cfgs = map[string]*ProgramConfig{"a": {DependsOn: []string{"x"}}}
+	if _, err := startOrder(cfgs); err == nil {
+		t.Fatal("expect error for unknown dependency")
+	}
+}
+
+// 依赖的程序Running之后才启动，依赖的程序失败时不启动，停止的顺序与启动相反
+func Test_StartAll(t *testing.T) {
+	db := helperConfig()
+	db.Environment = EnvMap{helperEnv: "1"}
+	app := helperConfig()
+	app.Environment = EnvMap{helperEnv: "1"}
+	app.DependsOn = []string{"db"}
+	bad := helperConfig()
+	bad.Command = "/nonexistent/supergo-test"
+	bad.MaxRetry = 1
+	bad.BackoffInitial = 1
+	job := helperConfig()
+	job.Environment = EnvMap{helperEnv: "1"}
+	job.DependsOn = []string{"bad"}
+
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	for name, cfg := range map[string]*ProgramConfig{"db": db, "app": app, "bad": bad, "job": job} {
+		if _, err := supervisor.AddProgram(name, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer supervisor.Exit()
+
+	var lock sync.Mutex
+	var launched []string
+	supervisor.StartAll(func(p *Program) error {
+		if p.Name == "app" && supervisor.GetProgram("db").Status().State != ProcessStateRunning {
+			t.Error("app is started before db is running")
+		}
+		lock.Lock()
+		launched = append(launched, p.Name)
+		lock.Unlock()
+		return p.StartProcess()
+	})
+	// bad和db互不依赖，并发启动
+	sort.Strings(launched)
+	if want := []string{"app", "bad", "db"}; !reflect.DeepEqual(launched, want) {
+		t.Fatalf("got launched %v, want %v", launched, want)
+	}
+	if s := supervisor.GetProgram("job").Status(); s.State != ProcessStateStopped {
+		t.Errorf("job is %s", s.State)
+	}
+
+	var stops []string
+	for _, p := range supervisor.stopOrder() {
+		stops = append(stops, p.Name)
+	}
+	if want := []string{"job", "app", "db", "bad"}; !reflect.DeepEqual(stops, want) {
+		t.Fatalf("got stop order %v, want %v", stops, want)
+	}
+}
+
+// 按照priority分批启动，依赖其他程序的程序不早于其依赖的程序所在的批次
+func Test_StartPriority(t *testing.T) {
+	cfgs := make(map[string]*ProgramConfig)
+	for name, priority := range map[string]int{"a": 10, "b": 5, "c": 1, "d": 0} {
+		cfg := helperConfig()
+		cfg.Environment = EnvMap{helperEnv: "1"}
+		cfg.Priority = priority
+		cfgs[name] = cfg
+	}
+	cfgs["d"].DependsOn = []string{"c"}
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	for name, cfg := range cfgs {
+		if _, err := supervisor.AddProgram(name, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer supervisor.Exit()
+
+	var lock sync.Mutex
+	var launched []string
+	supervisor.StartAll(func(p *Program) error {
+		lock.Lock()
+		launched = append(launched, p.Name)
+		lock.Unlock()
+		return p.StartProcess()
+	})
+	if want := []string{"c", "d", "b", "a"}; !reflect.DeepEqual(launched, want) {
+		t.Fatalf("got launched %v, want %v", launched, want)
+	}
+}
+
+// 依赖的程序Backoff时继续等待，不依赖它的程序不等待
+func Test_StartAllBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "db")
+	// 标记文件存在时db启动失败
+	if err := ioutil.WriteFile(marker+".", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := helperConfig()
+	db.Environment = EnvMap{helperEnv: "1", helperModeEnv: "marker", helperPidEnv: marker}
+	db.BackoffInitial = 1
+	app := helperConfig()
+	app.Environment = EnvMap{helperEnv: "1"}
+	app.DependsOn = []string{"db"}
+	free := helperConfig()
+	free.Environment = EnvMap{helperEnv: "1"}
+
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	for name, cfg := range map[string]*ProgramConfig{"db": db, "app": app, "free": free} {
+		if _, err := supervisor.AddProgram(name, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer supervisor.Exit()
+
+	go func() {
+		for supervisor.GetProgram("db").Status().State != ProcessStateBackoff {
+			time.Sleep(time.Millisecond * 50)
+		}
+		if s := supervisor.GetProgram("free").Status(); s.State == ProcessStateStopped {
+			t.Error("free is waiting for db")
+		}
+		os.Remove(marker + ".")
+	}()
+	supervisor.StartAll((*Program).StartProcess)
+	if s := supervisor.GetProgram("app").Status(); s.State != ProcessStateStarting {
+		t.Fatalf("app is %s after db recovered from backoff", s.State)
+	}
+}
+
+// reload新增的程序在其依赖的程序Running之后启动
+func Test_ReloadDependsOn(t *testing.T) {
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	db := helperConfig()
+	db.Environment = EnvMap{helperEnv: "1"}
+	app := helperConfig()
+	app.Environment = EnvMap{helperEnv: "1"}
+	app.DependsOn = []string{"db"}
+	if err := supervisor.Reload(map[string]*ProgramConfig{"db": db, "app": app}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		return supervisor.GetProgram("app").Status().State != ProcessStateStopped
+	}, "app is not started")
+	if s := supervisor.GetProgram("db").Status(); s.State != ProcessStateRunning {
+		t.Fatalf("app is started while db is %s", s.State)
+	}
+
+	// 更新了配置的程序同样等待依赖的程序Running之后才启动
+	newDB := *db
+	newDB.StartSecs = 2
+	newApp := *app
+	newApp.Environment = EnvMap{helperEnv: "1", "VERSION": "2"}
+	if err := supervisor.Reload(map[string]*ProgramConfig{"db": &newDB, "app": &newApp}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		return supervisor.GetProgram("app").Status().State != ProcessStateStopped
+	}, "app is not started after update")
+	if s := supervisor.GetProgram("db").Status(); s.State != ProcessStateRunning {
+		t.Fatalf("updated app is started while db is %s", s.State)
+	}
+}
@@ -0,0 +1,198 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetChildSubreaper = 36
+	prSetPdeathsig      = 1
+	soReusePort         = 0xf // syscall包中没有定义
+)
+
+func setSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// supergo退出时内核向子进程发送的信号，注意内核是在fork子进程的线程退出时发送
+func setPdeathsig(attr *syscall.SysProcAttr, sig syscall.Signal) error {
+	attr.Pdeathsig = sig
+	return nil
+}
+
+func setSelfPdeathsig(sig syscall.Signal) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetPdeathsig, uintptr(sig), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// 最大的信号，包括实时信号，即NSIG-1
+const maxSignal = 64
+
+const rlimInfinity = ^uint64(0)
+
+// 各架构相同的资源，syscall包中没有定义，取值来自include/uapi/asm-generic/resource.h
+const (
+	rlimitLocks      = 10
+	rlimitSigpending = 11
+	rlimitMsgqueue   = 12
+	rlimitNice       = 13
+	rlimitRtprio     = 14
+)
+
+// 资源限制的名称，同ulimit和systemd的Limit*，rss、nproc和memlock的取值与架构有关
+var rlimitResources = map[string]int{
+	"cpu":        syscall.RLIMIT_CPU,
+	"fsize":      syscall.RLIMIT_FSIZE,
+	"data":       syscall.RLIMIT_DATA,
+	"stack":      syscall.RLIMIT_STACK,
+	"core":       syscall.RLIMIT_CORE,
+	"rss":        rlimitRSS,
+	"nproc":      rlimitNproc,
+	"nofile":     syscall.RLIMIT_NOFILE,
+	"memlock":    rlimitMemlock,
+	"as":         syscall.RLIMIT_AS,
+	"locks":      rlimitLocks,
+	"sigpending": rlimitSigpending,
+	"msgqueue":   rlimitMsgqueue,
+	"nice":       rlimitNice,
+	"rtprio":     rlimitRtprio,
+}
+
+// fork之后的子进程中，/proc/self/exe即为supergo本身，即使二进制文件已经被替换
+func selfExe() string {
+	return "/proc/self/exe"
+}
+
+// 读取/proc/<pid>/stat，进程名中可能包含空格和括号，返回最后一个)之后的字段：state ppid pgrp ...
+func readProcStat(pid int) ([]string, error) {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return nil, syscall.EINVAL
+	}
+	return fields, nil
+}
+
+// 进程的启动时间，单位为系统启动之后的clock tick，与pid一起可以唯一的确定一个进程
+func procStartTime(pid int) (uint64, error) {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	// 僵尸进程已经退出
+	if fields[0] == "Z" {
+		return 0, syscall.ESRCH
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// 通过/proc找出父进程为supergo的进程
+func listChildren() ([]childProc, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	var procs []childProc
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fields, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		pgid, _ := strconv.Atoi(fields[2])
+		if ppid == self {
+			procs = append(procs, childProc{pid: pid, pgid: pgid})
+		}
+	}
+	return procs, nil
+}
+
+func setCPUAffinity(tid int, cpus []int) error {
+	var mask [maxCPUs / 64]uint64
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+func setIOPriority(tid int, class int, prio int) error {
+	if class == 0 {
+		prio = 0
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(class<<ioprioClassShift|prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setOOMScoreAdj(pid int, adj int) error {
+	return ioutil.WriteFile("/proc/"+strconv.Itoa(pid)+"/oom_score_adj", []byte(strconv.Itoa(adj)), 0644)
+}
+
+const ucredSize = syscall.SizeofUcred
+
+// 接收通知时附带发送者的pid
+func passCredentials(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// 发送者是进程本身或者其进程组中的进程，进程组ID即进程的pid。
+// 发送者已经退出时无法再查询其进程组，例如发送READY=1之后立即退出的systemd-notify，这时不拒绝
+func notifySenderAllowed(oob []byte, pid int) bool {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return false
+	}
+	for _, msg := range msgs {
+		cred, err := syscall.ParseUnixCredentials(&msg)
+		if err != nil {
+			continue
+		}
+		if int(cred.Pid) == pid {
+			return true
+		}
+		pgid, err := syscall.Getpgid(int(cred.Pid))
+		return err == syscall.ESRCH || (err == nil && pgid == pid)
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+//go:build !linux
+// +build !linux
+
+package supervisord
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+const soReusePort = syscall.SO_REUSEPORT
+
+func setSubreaper() error {
+	return errors.New("subreaper is only supported on linux")
+}
+
+func setPdeathsig(attr *syscall.SysProcAttr, sig syscall.Signal) error {
+	return errors.New("pdeathsig is only supported on linux")
+}
+
+func setSelfPdeathsig(sig syscall.Signal) error {
+	return errors.New("pdeathsig is only supported on linux")
+}
+
+// 最大的信号，darwin和openbsd只有31个信号，其他系统中更多的实时信号不会用来停止进程
+const maxSignal = 31
+
+const rlimInfinity = 1<<63 - 1
+
+var rlimitResources = map[string]int{
+	"cpu":    syscall.RLIMIT_CPU,
+	"fsize":  syscall.RLIMIT_FSIZE,
+	"data":   syscall.RLIMIT_DATA,
+	"stack":  syscall.RLIMIT_STACK,
+	"core":   syscall.RLIMIT_CORE,
+	"nofile": syscall.RLIMIT_NOFILE,
+}
+
+func selfExe() string {
+	exe, _ := os.Executable()
+	return exe
+}
+
+func procStartTime(pid int) (uint64, error) {
+	return 0, errors.New("adopt is only supported on linux")
+}
+
+func listChildren() ([]childProc, error) {
+	return nil, errors.New("not supported")
+}
+
+func setCPUAffinity(tid int, cpus []int) error {
+	return errors.New("cpu_affinity is only supported on linux")
+}
+
+func setIOPriority(tid int, class int, prio int) error {
+	return errors.New("io priority is only supported on linux")
+}
+
+func setOOMScoreAdj(pid int, adj int) error {
+	return errors.New("oom_score_adj is only supported on linux")
+}
+
+const ucredSize = 0
+
+// 只有Linux可以获取datagram socket发送者的pid，其他系统不检查
+func passCredentials(conn *net.UnixConn) error {
+	return nil
+}
+
+func notifySenderAllowed(oob []byte, pid int) bool {
+	return true
+}
@@ -1,49 +1,67 @@
 package supervisord
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"os/exec"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// Program可以被多个goroutine同时使用，lock保护程序以及进程的状态，
+// cmdLock保证同一个程序的start、stop、restart等命令依次执行
 type Program struct {
 	Name string
 	cfg  *ProgramConfig
 
+	lock           sync.Mutex
+	cmdLock        sync.Mutex
 	process        *Process
 	files          []*os.File
 	maxRetry       int
+	lastExitCode   int
+	backoffCancel  chan struct{} // Backoff状态下，关闭之后将取消重启
+	gen            int           // 每次start、stop、restart时递增，进程的gen与之不同时，说明已经被新的命令接管
 	logger         *log.Logger
-	listenerInited bool // listener是否已经初始化
-
-	status *ProgramStatus
+	listenerInited bool                   // listener是否已经初始化
+	cgroupDir      string                 // 程序的cgroup，没有使用cgroup时为空
+	spawning       int                    // runProcess中正在启动的进程数，被stop取消的进程退出之后才能删除cgroup
+	logWriters     map[string]*logWriter  // 日志文件的路径到logWriter
+	logBuffers     map[string]*ringBuffer // stdout、stderr在内存中保留的输出
+
+	status  *ProgramStatus
+	history []*StateTransition
 }
 
 // 完善信息
 type ProgramStatus struct {
-	Name      string   `json:"name,omitempty"`
-	Pid       int      `json:"pid,omitempty"`
-	StartTime int64    `json:"start_time,omitempty"`
-	StopTime  int64    `json:"stop_time,omitempty"`
-	State     string   `json:"state,omitempty"`
-	Listeners []string `json:"listeners,omitempty"`
-}
-
-type ProgramState string
-
-const (
-	ProcessStateStarting = "Starting"
-	ProcessStateRunning  = "Running"
-	// ProcessStateStopping = "Stopping"
-	ProcessStateStopped = "Stopped"
-	ProcessStateExited  = "Exited"
-	ProcessStateFatal   = "Fatal"
-	ProcessStateUnknown = "Unknown"
+	Name          string       `json:"name,omitempty"`
+	Pid           int          `json:"pid,omitempty"`
+	StartTime     int64        `json:"start_time,omitempty"`
+	StopTime      int64        `json:"stop_time,omitempty"`
+	State         ProgramState `json:"state,omitempty"`
+	Health        string       `json:"health,omitempty"`
+	StatusText    string       `json:"status_text,omitempty"`
+	NextRetryTime int64        `json:"next_retry_time,omitempty"` // Backoff状态下，下一次重启的时间
+	Listeners     []string     `json:"listeners,omitempty"`
+	Orphans       []int        `json:"orphans,omitempty"` // 过继给supergo，还没有退出的子孙进程
+	Cgroup        *CgroupStats `json:"cgroup,omitempty"`
+}
+
+// 退避时间随机抖动的比例
+const backoffJitter = 0.2
+
+var (
+	ErrProcessNotReady = errors.New("new process is not ready")
+	// 启动的过程中被stop或者restart了
+	errStartCanceled = errors.New("start canceled by stop or restart")
 )
 
 func NewProgram(name string, cfg *ProgramConfig) (p *Program, err error) {
@@ -62,23 +80,40 @@ func NewProgram(name string, cfg *ProgramConfig) (p *Program, err error) {
 	}
 
 	err = p.initListener()
+	if err != nil {
+		p.transition(ProcessStateFatal, "listen: "+err.Error())
+	}
 	return
 }
 
+// 调用时需要持有program.lock
 func (program *Program) initListener() error {
-	if !program.listenerInited {
+	if program.listenerInited {
+		return nil
+	}
+	if program.cfg.instanceOf != "" {
+		return program.acquireListener()
+	}
+	return program.openListener()
+}
+
+// 调用时需要持有program.lock
+func (program *Program) openListener() error {
+	if !program.inheritListener() {
 		var files []*os.File
 		for _, addr := range program.cfg.ListenAddrs {
 			var l net.Listener
 			var f *os.File
-			l, err := net.Listen("tcp", addr)
+			lc := net.ListenConfig{}
+			if program.cfg.Adopt {
+				lc.Control = reusePortControl
+			}
+			l, err := lc.Listen(context.Background(), "tcp", addr)
 			if err != nil {
-				program.status.State = ProcessStateFatal
 				return err
 			}
 			f, err = l.(*net.TCPListener).File()
 			if err != nil {
-				program.status.State = ProcessStateFatal
 				l.Close()
 				return err
 			}
@@ -91,232 +126,731 @@ func (program *Program) initListener() error {
 	return nil
 }
 
+// 调用时需要持有program.lock
 func (program *Program) closeListener() {
-	for _, l := range program.files {
-		l.Close()
+	if !program.listenerInited {
+		return
+	}
+	if program.cfg.instanceOf != "" {
+		program.releaseListener()
+	} else {
+		for _, l := range program.files {
+			l.Close()
+		}
 	}
 	program.listenerInited = false
 }
 
 func (program *Program) Destory() {
+	program.lock.Lock()
 	program.closeListener()
+	logs := program.releaseLogs()
+	program.closeLogBuffers()
+	program.lock.Unlock()
+	for _, w := range logs {
+		w.Close()
+	}
+	program.removeCgroup()
 }
 
+// 返回状态的拷贝，调用者可以随意读取
 func (program *Program) Status() *ProgramStatus {
-	return program.status
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	status := *program.status
+	status.Listeners = append([]string(nil), program.status.Listeners...)
+	status.Orphans = childReaper.orphansOf(program)
+	if program.cgroupDir != "" {
+		status.Cgroup = readCgroupStats(program.cgroupDir)
+	}
+	return &status
 }
 
 type Process struct {
-	cmd      *exec.Cmd
-	stopChan chan struct{}
-	spawn    bool // 标识是否是手动restart
+	cmd       *exec.Cmd // 接管的进程不是supergo启动的，cmd为nil
+	pid       int
+	env       []string
+	proc      *os.Process // 升级之后接管的子进程
+	adopted   bool        // supergo重新启动或者升级之后接管的进程
+	stopChan  chan struct{}
+	readyChan chan struct{} // 进程就绪之后关闭
+	startTime int64
+	exitCode  int  // 进程退出之后设置，stopChan关闭之后才能读取
+	gen       int  // 启动进程时program的gen
+	standby   bool // 重启时启动的新进程，就绪之前由重启的流程管理，退出时不会自动重启
+
+	healthyChan chan struct{} // 健康检查第一次成功之后关闭
+
+	notify          *notifySocket
+	notifyReadyChan chan struct{} // 收到READY=1之后关闭
+	statusText      string        // 进程通过STATUS=发送的状态信息
+	lastKeepalive   atomic.Int64  // 最后一次收到WATCHDOG=1的时间，atomic.Int64在32位平台上也保证8字节对齐
+	hung            bool          // 被watchdog判定为挂起
+	stopping        bool          // 正在停止，停止的过程中不再发送心跳，watchdog不再检查
+	oomKills        uint64        // 启动时cgroup中OOM kill的次数
+	stdoutPipe      *os.File      // 进程输出管道的读端，升级时传递给新的supergo
+	stderrPipe      *os.File
 }
 
-func (program *Program) StartProcess() {
-	if program.status.State != ProcessStateStopped && program.status.State != ProcessStateExited && program.status.State != ProcessStateFatal {
-		return
+func (program *Program) StartProcess() error {
+	program.cmdLock.Lock()
+	defer program.cmdLock.Unlock()
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	// Running等状态也可以转换为Starting，但那是重启，start只能启动没有运行的程序
+	switch program.status.State {
+	case ProcessStateStopped, ProcessStateExited, ProcessStateFatal:
+	default:
+		return &StateTransitionError{Program: program.Name, From: program.status.State, To: ProcessStateStarting}
 	}
-	program.logger.Printf("start")
-	program.status.State = ProcessStateStarting
-	program.initListener()
-	go program.startNewProcess()
-	return
+	if err := program.transition(ProcessStateStarting, "start"); err != nil {
+		return err
+	}
+	program.gen++
+	if err := program.initListener(); err != nil {
+		program.transition(ProcessStateFatal, "listen: "+err.Error())
+		return err
+	}
+	go program.runProcess(nil, program.gen)
+	return nil
 }
 
-func (program *Program) startNewProcess() {
+// 运行并监控进程，进程异常退出时根据配置循环重启，process为nil时将启动一个新的进程，
+// gen与program的gen不同时，说明已经被stop或者restart接管，不再继续
+func (program *Program) runProcess(process *Process, gen int) {
 	defer func() {
 		if err := recover(); err != nil {
 			log.Println(err)
-			program.status.State = ProcessStateUnknown
+			program.lock.Lock()
+			program.transition(ProcessStateUnknown, fmt.Sprint(err))
+			program.lock.Unlock()
 		}
 	}()
-	var stderr, stdout *os.File
-	var err error
-	if program.cfg.StderrLogFile != "" {
-		stderr, err = os.OpenFile(program.cfg.StderrLogFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
-		if err != nil {
-			program.logger.Printf("open file %s: %s", program.cfg.StderrLogFile, err.Error())
+	for {
+		force := false
+		if process == nil {
+			program.lock.Lock()
+			program.spawning++
+			program.lock.Unlock()
+			var err error
+			process, err = program.spawnProcess(gen, false)
+			if err != nil {
+				program.logger.Printf("start error: %s", err.Error())
+				program.endSpawn()
+			} else {
+				program.lock.Lock()
+				if program.gen != gen {
+					// 启动的过程中被stop或者restart了
+					program.lock.Unlock()
+					program.stopProc(process)
+					program.endSpawn()
+					return
+				}
+				program.spawning--
+				program.process = process
+				program.status.StartTime = process.startTime
+				program.status.Pid = process.pid
+				program.recordProcess(process)
+				program.lock.Unlock()
+			}
+		}
+		if process != nil {
+			program.watchProcess(process)
+			program.lock.Lock()
+			// 如果是被stop或者restart停止的，该进程则不需要自动重启
+			managed := process.standby || process.gen != program.gen
+			// 重启失败时，老的进程会被重新设置为当前的gen
+			gen = process.gen
+			force = process.hung
+			program.lock.Unlock()
+			if managed {
+				return
+			}
+		}
+		if !program.shouldRetry(gen, force) {
+			return
 		}
+		process = nil
 	}
-	if program.cfg.StdoutLogFile != "" {
-		stdout, err = os.OpenFile(program.cfg.StdoutLogFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+}
+
+// 创建并启动一个新的进程，standby为true时表示重启时启动的新进程
+func (program *Program) spawnProcess(gen int, standby bool) (*Process, error) {
+	cred, err := program.cfg.credential()
+	if err != nil {
+		return nil, err
+	}
+	mask, err := program.cfg.umask()
+	if err != nil {
+		return nil, err
+	}
+	env, err := program.cfg.environ()
+	if err != nil {
+		return nil, err
+	}
+	path, args, err := program.cfg.commandArgs(env)
+	if err != nil {
+		return nil, err
+	}
+	var notify *notifySocket
+	if program.cfg.Notify {
+		notify, err = listenNotify(program.Name)
 		if err != nil {
-			program.logger.Printf("open file %s: %s", program.cfg.StdoutLogFile, err.Error())
+			return nil, err
+		}
+		if cred != nil {
+			os.Chown(notify.path, int(cred.Uid), int(cred.Gid))
 		}
 	}
-	progCmds := strings.Split(strings.TrimSpace(program.cfg.Command), " ")
+	// 接管的进程退出之后，才会重新创建listener。
+	// 并发的StopProcess可能已经关闭了listener并删除了cgroup，gen变化之后不能再重新创建
+	program.lock.Lock()
+	if program.gen != gen {
+		program.lock.Unlock()
+		if notify != nil {
+			notify.Close()
+		}
+		return nil, errStartCanceled
+	}
+	err = program.initListener()
+	files := program.files
+	var cgroupDir string
+	if err == nil {
+		cgroupDir = program.setupCgroup()
+		program.cgroupDir = cgroupDir
+	}
+	program.lock.Unlock()
+	if err != nil {
+		if notify != nil {
+			notify.Close()
+		}
+		return nil, err
+	}
 	cmd := &exec.Cmd{
 		Dir:        program.cfg.Directory,
-		Path:       progCmds[0],
-		Args:       append(progCmds, program.cfg.Args...),
-		Stderr:     stderr,
-		Stdout:     stdout,
-		ExtraFiles: program.files, // 传递文件描述符
+		Path:       path,
+		Args:       args,
+		ExtraFiles: files, // 传递文件描述符
 		SysProcAttr: &syscall.SysProcAttr{
-			Setpgid: true, // 设置进程组ID为自己
+			Setpgid:    true, // 设置进程组ID为自己
+			Credential: cred, // 以配置的用户和组运行
 		},
 	}
+	if program.cfg.Pdeathsig != "" {
+		sig, _ := parseSignal(program.cfg.Pdeathsig)
+		if err := setPdeathsig(cmd.SysProcAttr, sig); err != nil {
+			if notify != nil {
+				notify.Close()
+			}
+			return nil, err
+		}
+	}
+	cmd.Env = env
+	if notify != nil {
+		cmd.Env = append(cmd.Env, NotifySocketEnv+"="+notify.path)
+		if program.cfg.WatchdogSec > 0 {
+			cmd.Env = append(cmd.Env, watchdogEnv(program.cfg.WatchdogSec))
+		}
+	}
+	// 进程看到的环境变量，不包含shim使用的
+	procEnv := cmd.Env
+	var oomKills uint64
+	if cgroupDir != "" {
+		oomKills = readCgroupStats(cgroupDir).OOMKills
+	}
+	if program.cfg.needShim() || cgroupDir != "" {
+		if err := program.useShim(cmd, cred, mask, cgroupDir); err != nil {
+			if notify != nil {
+				notify.Close()
+			}
+			return nil, err
+		}
+	}
+
+	// 进程的输出通过管道由supergo写入日志文件，stderrPipe和stdoutPipe为管道的读端
+	stderrPipe, stderr, err := program.outputPipe(program.cfg.stderrLogFile(), cred)
+	if err != nil {
+		program.logger.Printf("open file %s: %s", program.cfg.stderrLogFile(), err.Error())
+	}
+	stdoutPipe, stdout, err := program.outputPipe(program.cfg.StdoutLogFile, cred)
+	if err != nil {
+		program.logger.Printf("open file %s: %s", program.cfg.StdoutLogFile, err.Error())
+	}
+	closePipes := func() {
+		for _, f := range []*os.File{stderrPipe, stdoutPipe} {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}
+	cmd.Stderr = stderr
+	cmd.Stdout = stdout
 
 	process := &Process{
-		cmd:      cmd,
-		stopChan: make(chan struct{}, 1),
+		cmd:             cmd,
+		env:             procEnv,
+		oomKills:        oomKills,
+		stopChan:        make(chan struct{}),
+		readyChan:       make(chan struct{}),
+		gen:             gen,
+		standby:         standby,
+		healthyChan:     make(chan struct{}),
+		notify:          notify,
+		notifyReadyChan: make(chan struct{}),
+		stdoutPipe:      stdoutPipe,
+		stderrPipe:      stderrPipe,
 	}
 
 	err = process.run()
+	process.startTime = time.Now().Unix()
 	if stderr != nil {
 		stderr.Close()
 	}
 	if stdout != nil {
 		stdout.Close()
 	}
-	if err == nil {
-		program.status.StartTime = time.Now().Unix()
-		program.status.Pid = process.cmd.Process.Pid
+	if err != nil {
+		if notify != nil {
+			notify.Close()
+		}
+		closePipes()
+		return nil, err
+	}
+	program.copyOutputs(process, cred)
+	// Setpgid之后进程组ID即为进程的pid
+	childReaper.addGroup(process.pid, program)
+	if notify != nil {
+		go program.readNotify(process)
+	}
+	go program.waitProcess(process)
+	if program.cfg.HealthCheck != nil {
+		go program.checkHealth(process)
+	}
+	return process, nil
+}
 
-		type processResult struct {
-			exitCode int
-			err      error
+// 等待进程退出，关闭stopChan
+func (program *Program) waitProcess(process *Process) {
+	exitCode, err := process.wait()
+	if err != nil {
+		program.logger.Printf("wait error: %s", err.Error())
+	}
+	process.exitCode = exitCode
+	if process.notify != nil {
+		process.notify.Close()
+	}
+	processStates.remove(program.Name, process.pid)
+	// 进程执行完毕，可能是程序自动退出，也可能是通过stop退出
+	close(process.stopChan)
+}
+
+// 等待进程就绪以及退出
+func (program *Program) watchProcess(process *Process) {
+	// 重启时启动的新进程由重启的流程处理超时
+	var timeout <-chan time.Time
+	program.lock.Lock()
+	standby := process.standby
+	if !standby {
+		timeout = time.After(time.Second * time.Duration(program.cfg.ReadyTimeout))
+	}
+	program.lock.Unlock()
+	select {
+	// 如果进程启动之后迅速的退出，说明进程本身有问题，需要在进程就绪之后，才将重试的次数设置为0，
+	// 防止进程因为异常一直重启而不会被发现
+	case <-program.readySignal(process, standby):
+		// 进程就绪之后，才能设置为Running
+		close(process.readyChan)
+		program.lock.Lock()
+		// 重启时启动的新进程，由重启的流程设置为Running
+		if !process.standby && process.gen == program.gen {
+			program.setRunning(process, "ready")
+		}
+		program.lock.Unlock()
+		if program.cfg.WatchdogSec > 0 {
+			go program.watchdog(process)
 		}
-		var result *processResult
-		resultChan := make(chan *processResult, 1)
+		<-process.stopChan
+
+	case <-process.stopChan:
+	case <-timeout:
+		program.logger.Printf("not ready in %d seconds", program.cfg.ReadyTimeout)
+		program.stopProc(process)
+	}
+
+	program.logger.Printf("exit with code %d", process.exitCode)
+	program.checkOOMKill(process)
+	program.lock.Lock()
+	program.lastExitCode = process.exitCode
+	program.lock.Unlock()
+}
+
+// 进程就绪的信号，开启了notify时需要进程发送READY=1，配置了健康检查时需要第一次检查成功，两者都配置时都需要满足，
+// 都没有配置时进程运行一段时间后即认为就绪。先启动后停止的方式重启时（standby），新老进程共用listener，
+// 检查的地址可能由老的进程响应，所以只以新进程自己的READY=1或者start_secs为准
+func (program *Program) readySignal(process *Process, standby bool) <-chan struct{} {
+	ready := make(chan struct{})
+	// 接管的进程之前已经就绪
+	if process.adopted {
+		close(ready)
+		return ready
+	}
+	var signals []<-chan struct{}
+	if program.cfg.Notify {
+		signals = append(signals, process.notifyReadyChan)
+	}
+	if program.cfg.HealthCheck != nil && !standby {
+		signals = append(signals, process.healthyChan)
+	}
+	if len(signals) == 0 {
 		go func() {
-			exitCode, err := process.wait()
-			resultChan <- &processResult{exitCode, err}
+			select {
+			case <-time.After(time.Second * time.Duration(program.cfg.StartSecs)):
+				close(ready)
+			case <-process.stopChan:
+			}
 		}()
-		select {
-		// 如果进程启动之后迅速的退出，说明进程本身有问题，需要在进程启动一定的时间之后，才将重试的次数设置为0，
-		// 防止进程因为异常一直重启而不会被发现
-		case <-time.After(time.Second):
-			// 进程运行一段时间后，才能设置为Running
-			// TODO: 该时间可配置
-			program.status.State = ProcessStateRunning
-			program.maxRetry = 0
-			program.process = process
-
-			result = <-resultChan
-
-		case result = <-resultChan:
+		return ready
+	}
+	go func() {
+		for _, signal := range signals {
+			select {
+			case <-signal:
+			case <-process.stopChan:
+				return
+			}
 		}
+		close(ready)
+	}()
+	return ready
+}
 
-		// 进程执行完毕，可能是程序自动退出，也可能是通过stop退出
-		close(process.stopChan)
-		if err != nil {
-			program.logger.Printf("wait error: %s", result.err.Error())
-		}
-		program.logger.Printf("exit with code %d", result.exitCode)
-		// 如果是restart，该进程则不需要自动重启
-		if process.spawn {
-			return
-		}
-	} else {
-		program.logger.Printf("start error: %s", err.Error())
+// 调用时需要持有program.lock
+func (program *Program) setRunning(process *Process, reason string) {
+	if err := program.transition(ProcessStateRunning, reason); err != nil {
+		program.logger.Println(err.Error())
+		return
 	}
-	program.shouldRetry()
+	program.status.Health = ""
+	select {
+	case <-process.healthyChan:
+		// 启动时进程就绪即说明已经通过了检查，重启时的新进程在就绪之前不检查，由之后的检查更新
+		program.status.Health = HealthStateHealthy
+	default:
+	}
+	program.status.StartTime = process.startTime
+	program.status.Pid = process.pid
+	program.status.StatusText = process.statusText
+	program.maxRetry = 0
+	program.process = process
+	program.recordProcess(process)
 }
 
-func (program *Program) shouldRetry() {
-	// 如果是被手动停止的，则不需要重启
-	if program.status.State == ProcessStateStopped {
-		return
+// 判断进程退出之后是否需要重启，需要时等待退避的时间后返回true
+// force为true时，即使没有配置自动重启也会重启，例如进程被watchdog判定为挂起
+func (program *Program) shouldRetry(gen int, force bool) bool {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	// 如果是被手动停止或者重启的，则不需要重启
+	if program.gen != gen {
+		return false
 	}
-	if program.cfg.AutoRestart {
+	if program.cfg.AutoRestart || force {
 		program.maxRetry++
 		if program.maxRetry <= program.cfg.MaxRetry {
-			time.Sleep(time.Second * 1)
-			program.logger.Printf("retry %d", program.maxRetry)
-			program.startNewProcess()
-		} else {
-			program.logger.Printf("max retry excessed")
-			// 进程异常重启的次数超过最大值，进程的状态将设置为Fatal
-			program.status.State = ProcessStateFatal
-			program.status.StopTime = time.Now().Unix()
+			delay := program.cfg.backoffDelay(program.maxRetry)
+			if err := program.transition(ProcessStateBackoff, fmt.Sprintf("retry %d after %s", program.maxRetry, delay)); err != nil {
+				program.logger.Println(err.Error())
+				return false
+			}
+			program.status.NextRetryTime = time.Now().Add(delay).Unix()
 			program.process = nil
-			program.closeListener()
+			cancel := make(chan struct{})
+			program.backoffCancel = cancel
+
+			program.lock.Unlock()
+			select {
+			case <-time.After(delay):
+			case <-cancel:
+			}
+			program.lock.Lock()
+
+			// 退避期间被手动停止或者重启了
+			if program.gen != gen {
+				return false
+			}
+			program.backoffCancel = nil
+			program.status.NextRetryTime = 0
+			if err := program.transition(ProcessStateStarting, fmt.Sprintf("retry %d", program.maxRetry)); err != nil {
+				program.logger.Println(err.Error())
+				return false
+			}
+			return true
+		}
+		// 进程异常重启的次数超过最大值，进程的状态将设置为Fatal
+		if err := program.transition(ProcessStateFatal, "max retry excessed"); err != nil {
+			program.logger.Println(err.Error())
+			return false
 		}
+		program.status.StopTime = time.Now().Unix()
+		program.status.Health = ""
+		program.status.StatusText = ""
+		program.process = nil
+		program.closeListener()
 	} else {
-		program.logger.Printf("exited")
 		// 进程正常的结束，状态为Exited
-		program.status.State = ProcessStateExited
+		if err := program.transition(ProcessStateExited, fmt.Sprintf("exited with code %d", program.lastExitCode)); err != nil {
+			program.logger.Println(err.Error())
+			return false
+		}
 		program.status.StopTime = time.Now().Unix()
+		program.status.Health = ""
+		program.status.StatusText = ""
 		program.closeListener()
 		program.process = nil
 	}
+	return false
 }
 
-func (program *Program) RestartProess() (process *Process) {
-	if program.status.State == ProcessStateStarting {
-		return
+// 取消Backoff状态下等待中的重启，调用时需要持有program.lock
+func (program *Program) cancelBackoff() {
+	if program.backoffCancel != nil {
+		close(program.backoffCancel)
+		program.backoffCancel = nil
+	}
+	program.status.NextRetryTime = 0
+}
+
+// 第retry次重启前等待的时间，按照倍数递增，不超过backoff_max，并加入一定的随机抖动，
+// 避免多个进程同时重启
+func (cfg *ProgramConfig) backoffDelay(retry int) time.Duration {
+	delay := float64(cfg.BackoffInitial)
+	for i := 1; i < retry; i++ {
+		delay *= cfg.BackoffMultiplier
+		if delay >= float64(cfg.BackoffMax) {
+			break
+		}
 	}
-	program.logger.Printf("restart")
+	if delay > float64(cfg.BackoffMax) {
+		delay = float64(cfg.BackoffMax)
+	}
+	delay *= 1 + backoffJitter*(rand.Float64()*2-1)
+	return time.Duration(delay * float64(time.Second))
+}
+
+func (program *Program) RestartProess() error {
+	program.cmdLock.Lock()
+	defer program.cmdLock.Unlock()
+	return program.restart()
+}
+
+// 调用时需要持有program.cmdLock
+func (program *Program) restart() error {
+	program.lock.Lock()
+	if err := program.transition(ProcessStateStarting, "restart"); err != nil {
+		program.lock.Unlock()
+		return err
+	}
+	program.cancelBackoff()
+	// 老的进程在重启的过程中退出时，不需要自动重启
+	program.gen++
+	gen := program.gen
 	oldProc := program.process
-	program.status.State = ProcessStateStarting
 	program.maxRetry = 0
-	if program.cfg.StopBeforeRestart {
+	if program.cfg.StopBeforeRestart || oldProc == nil {
+		program.lock.Unlock()
 		// 先停止，后启动新的进程
 		if oldProc != nil {
-			oldProc.spawn = true
 			program.stopProc(oldProc)
 		}
+		program.lock.Lock()
+		defer program.lock.Unlock()
 		// 重启时也需要检查listener是否已经初始化
-		program.initListener()
-		go program.startNewProcess()
-
-	} else {
-		// 先启动新的进程，后停止
-		// 重启时也需要检查listener是否已经初始化
-		program.initListener()
-		go program.startNewProcess()
-		// TODO: 第二个进程启动失败时，第一个进程可以不停止，此时进程应该处于另一种状态
-		if oldProc != nil {
-			oldProc.spawn = true
-			program.stopProc(oldProc)
+		if err := program.initListener(); err != nil {
+			program.transition(ProcessStateFatal, "listen: "+err.Error())
+			return err
 		}
+		go program.runProcess(nil, gen)
+		return nil
 	}
 
-	return
+	// 先启动新的进程，新的进程就绪之后再停止老的进程
+	// 重启时也需要检查listener是否已经初始化
+	err := program.initListener()
+	program.lock.Unlock()
+	if err != nil {
+		return program.restartFailed(oldProc, err)
+	}
+	newProc, err := program.spawnProcess(gen, true)
+	if err != nil {
+		return program.restartFailed(oldProc, err)
+	}
+	go program.runProcess(newProc, gen)
+
+	select {
+	case <-newProc.readyChan:
+	case <-newProc.stopChan:
+		return program.restartFailed(oldProc, ErrProcessNotReady)
+	case <-time.After(time.Second * time.Duration(program.cfg.ReadyTimeout)):
+		// 新的进程没有在规定的时间内就绪，将其停止，老的进程继续运行
+		program.stopProc(newProc)
+		return program.restartFailed(oldProc, ErrProcessNotReady)
+	}
+
+	program.lock.Lock()
+	newProc.standby = false
+	program.setRunning(newProc, "restarted")
+	program.lock.Unlock()
+	program.stopProc(oldProc)
+	return nil
 }
 
-func (program *Program) StopProcess() (exitCode int) {
-	if program.status.State != ProcessStateRunning {
-		return
+// 重启失败时，老的进程继续运行，进程处于RestartFailed状态
+func (program *Program) restartFailed(oldProc *Process, err error) error {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	select {
+	case <-oldProc.stopChan:
+		// 老的进程在重启的过程中也退出了，其runProcess因为gen变化已经返回，由这里按照auto_restart重试
+		program.logger.Printf("restart failed and old process exited: %s", err.Error())
+		program.lastExitCode = oldProc.exitCode
+		gen := program.gen
+		go func() {
+			if program.shouldRetry(gen, false) {
+				program.runProcess(nil, gen)
+			}
+		}()
+	default:
+		// 老的进程继续由原来的流程监控
+		oldProc.gen = program.gen
+		program.transition(ProcessStateRestartFailed, err.Error())
 	}
-	program.logger.Printf("stop")
+	return err
+}
+
+func (program *Program) StopProcess() error {
+	program.cmdLock.Lock()
+	defer program.cmdLock.Unlock()
+	program.lock.Lock()
+	if err := program.transition(ProcessStateStopping, "stop"); err != nil {
+		program.lock.Unlock()
+		return err
+	}
+	program.cancelBackoff()
+	program.gen++
 	proc := program.process
-	program.status.State = ProcessStateStopped
-	program.stopProc(proc)
+	program.lock.Unlock()
+	// 正在启动中的进程，启动之后发现gen已经变化，会自行停止
+	if proc != nil {
+		program.stopProc(proc)
+	}
+	removeCgroupDir(program.setStopped())
+	return nil
+}
+
+// 进程停止之后转换为Stopped，返回需要删除的cgroup，由调用者在释放program.lock之后删除
+func (program *Program) setStopped() string {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	program.transition(ProcessStateStopped, "stopped")
 	program.status.StopTime = time.Now().Unix()
+	program.status.Health = ""
+	program.status.StatusText = ""
 	// program.status.Pid = 0
 	program.closeListener()
 	program.process = nil
-	return
+	// 被取消的启动中的进程可能还在运行，由runProcess在其退出之后删除
+	if program.spawning > 0 {
+		return ""
+	}
+	return program.takeCgroup()
+}
+
+// runProcess中的启动结束，启动的过程中程序被stop时，在被取消的进程退出之后删除cgroup
+func (program *Program) endSpawn() {
+	program.lock.Lock()
+	program.spawning--
+	var dir string
+	if program.spawning == 0 && program.status.State == ProcessStateStopped {
+		dir = program.takeCgroup()
+	}
+	program.lock.Unlock()
+	removeCgroupDir(dir)
 }
 
 func (program *Program) stopProc(proc *Process) error {
-	// TODO: 允许配置进程退出的时发送的信号量
-	if err := proc.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+	program.lock.Lock()
+	proc.stopping = true
+	program.lock.Unlock()
+	steps, err := program.cfg.stopSteps()
+	if err != nil {
 		program.logger.Printf("stop process %s", err.Error())
 	}
-	select {
-	case <-proc.stopChan:
-	case <-time.After(time.Second * time.Duration(program.cfg.StopTimeout)):
-		if err := proc.cmd.Process.Signal(syscall.SIGKILL); err != nil {
-			program.logger.Printf("kill process %s", err.Error())
+	for _, step := range steps {
+		if err := program.signal(proc, step.signal); err != nil {
+			program.logger.Printf("stop process with %s: %s", step.signal, err.Error())
 		}
+		select {
+		case <-proc.stopChan:
+			program.killGroup(proc)
+			return nil
+		case <-time.After(step.wait):
+		}
+	}
+	// 超时之后进程还没有退出，强行KILL
+	if err := program.signal(proc, syscall.SIGKILL); err != nil {
+		program.logger.Printf("kill process %s", err.Error())
 	}
+	<-proc.stopChan
+	program.killGroup(proc)
 
 	return nil
 }
 
+// 配置了kill_group时，信号发送给整个进程组
+func (program *Program) signal(proc *Process, sig syscall.Signal) error {
+	if program.cfg.KillGroup {
+		return syscall.Kill(-proc.pid, sig)
+	}
+	return proc.signal(sig)
+}
+
+// 进程退出之后，KILL进程组中剩余的进程
+func (program *Program) killGroup(proc *Process) {
+	if !program.cfg.KillGroup {
+		return
+	}
+	if err := syscall.Kill(-proc.pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		program.logger.Printf("kill process group %d: %s", proc.pid, err.Error())
+	}
+}
+
 func (process *Process) run() error {
-	return process.cmd.Start()
+	if err := startChild(process.cmd); err != nil {
+		return err
+	}
+	process.pid = process.cmd.Process.Pid
+	return nil
+}
+
+func (process *Process) signal(sig syscall.Signal) error {
+	if process.proc != nil {
+		return process.proc.Signal(sig)
+	}
+	if process.cmd == nil {
+		return syscall.Kill(process.pid, sig)
+	}
+	return process.cmd.Process.Signal(sig)
 }
 
 func (process *Process) wait() (exitCode int, err error) {
-	err = process.cmd.Wait()
+	if process.adopted {
+		return process.waitAdopted()
+	}
+	err = waitChild(process.cmd)
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			exitCode, _ = getExitCode(exitErr.Sys())
-			return
+			return exitCode, nil
 		} else {
 			return 0, err
 		}
@@ -0,0 +1,211 @@
+package supervisord
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	helperEnv     = "SUPERGO_TEST_HELPER"
+	helperModeEnv = "SUPERGO_TEST_HELPER_MODE" // fork: 启动一个子进程之后等待SIGTERM，orphan: 启动一个子进程之后直接退出，rlimit: 写入资源限制
+	helperPidEnv  = "SUPERGO_TEST_HELPER_PIDFILE"
+	// notify模式下启动之后发送的通知
+	helperNotifyEnv = "SUPERGO_TEST_HELPER_NOTIFY"
+	// health模式下提供健康检查的地址
+	helperAddrEnv = "SUPERGO_TEST_HELPER_ADDR"
+)
+
+// 作为测试中的子进程运行，收到SIGTERM之后退出
+func Test_HelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) != "1" {
+		return
+	}
+	switch os.Getenv(helperModeEnv) {
+	case "fork", "orphan":
+		child := exec.Command("sleep", os.Getenv("SUPERGO_TEST_HELPER_SLEEP"))
+		if err := child.Start(); err != nil {
+			os.Exit(2)
+		}
+		ioutil.WriteFile(os.Getenv(helperPidEnv), []byte(strconv.Itoa(child.Process.Pid)), 0644)
+		if os.Getenv(helperModeEnv) == "orphan" {
+			os.Exit(0)
+		}
+	case "rlimit":
+		// 写入资源限制nofile和core
+		var nofile, core syscall.Rlimit
+		syscall.Getrlimit(syscall.RLIMIT_NOFILE, &nofile)
+		syscall.Getrlimit(syscall.RLIMIT_CORE, &core)
+		ioutil.WriteFile(os.Getenv(helperPidEnv), []byte(fmt.Sprintf("%d %d %d", nofile.Max, core.Cur, core.Max)), 0644)
+	case "marker":
+		// 实例的标记文件存在时启动失败
+		if _, err := os.Stat(os.Getenv(helperPidEnv) + "." + os.Getenv(instanceEnv)); err == nil {
+			os.Exit(1)
+		}
+	case "umask":
+		// 写入进程的umask
+		mask := syscall.Umask(0)
+		ioutil.WriteFile(os.Getenv(helperPidEnv), []byte(strconv.FormatInt(int64(mask), 8)), 0644)
+	case "output":
+		// 在stdout和stderr各输出一行
+		fmt.Println("hello stdout")
+		fmt.Fprintln(os.Stderr, "hello stderr")
+	case "notify":
+		// 发送通知，收到SIGUSR1时发送READY=1，收到SIGUSR2时停止心跳
+		notifyHelper()
+	case "signals":
+		// 记录收到的每个信号及其时间，不会自行退出，只能被KILL
+		signalHelper()
+	case "notify-child":
+		// 由子进程发送READY=1，子进程发送之后立即退出
+		child := exec.Command(os.Args[0], os.Args[1:]...)
+		child.Env = append(os.Environ(), helperModeEnv+"=notify-send")
+		if err := child.Run(); err != nil {
+			os.Exit(2)
+		}
+	case "notify-send":
+		conn, err := net.Dial("unixgram", os.Getenv(NotifySocketEnv))
+		if err != nil {
+			os.Exit(2)
+		}
+		conn.Write([]byte("READY=1"))
+		os.Exit(0)
+	case "health":
+		// 提供健康检查的http服务，标记文件存在时返回500
+		go healthHelper()
+	case "upgrade":
+		// 作为supergo启动一个设置了pdeathsig的进程之后升级
+		upgradeHelper()
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM)
+	<-c
+	os.Exit(0)
+}
+
+// 使用测试程序本身作为子进程
+func helperConfig() *ProgramConfig {
+	cfg := &ProgramConfig{
+		Command:     os.Args[0] + " -test.run=^Test_HelperProcess$",
+		AutoRestart: true,
+	}
+	cfg.setDefaults()
+	return cfg
+}
+
+// 等待条件满足，超时之后测试失败
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func Test_BackoffDelay(t *testing.T) {
+	cfg := &ProgramConfig{}
+	cfg.setDefaults()
+	cfg.BackoffMax = 10
+	for retry, want := range map[int]float64{1: 1, 2: 2, 3: 4, 4: 8, 5: 10, 20: 10} {
+		delay := cfg.backoffDelay(retry)
+		lo := time.Duration(want * (1 - backoffJitter) * float64(time.Second))
+		hi := time.Duration(want * (1 + backoffJitter) * float64(time.Second))
+		if delay < lo || delay > hi {
+			t.Errorf("retry %d: delay %s not in [%s, %s]", retry, delay, lo, hi)
+		}
+	}
+}
+
+// 并发的执行start、stop、restart和status，配合-race检查数据竞争
+func Test_ConcurrentCommands(t *testing.T) {
+	os.Setenv(helperEnv, "1")
+	defer os.Unsetenv(helperEnv)
+
+	prog, err := NewProgram("stress", helperConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(3 * time.Second)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				switch rand.Intn(4) {
+				case 0:
+					prog.StartProcess()
+				case 1:
+					prog.StopProcess()
+				case 2:
+					prog.RestartProess()
+				case 3:
+					prog.Status()
+					prog.History()
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 正在启动的进程不能停止，等待其就绪之后再停止
+	for i := 0; i < 100; i++ {
+		if prog.StopProcess() == nil || prog.Status().State == ProcessStateStopped {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if state := prog.Status().State; state != ProcessStateStopped {
+		t.Fatalf("unexpected state %s", state)
+	}
+	prog.Destory()
+
+	// 所有的子进程都应该已经退出并被回收
+	var ws syscall.WaitStatus
+	if pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil); err != syscall.ECHILD {
+		t.Errorf("child process %d still alive: %v", pid, err)
+		out, _ := exec.Command("sh", "-c", fmt.Sprintf("ps -o pid,ppid,args --ppid %d", os.Getpid())).CombinedOutput()
+		t.Log(string(out))
+		for _, h := range prog.History() {
+			t.Logf("%+v", *h)
+		}
+	}
+}
+
+// 启动的过程中gen已经变化时，不再重新创建listener
+func Test_SpawnStaleGen(t *testing.T) {
+	cfg := helperConfig()
+	cfg.ListenAddrs = []string{"127.0.0.1:0"}
+	program, err := NewProgram("stale", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟并发的StopProcess：gen增加并且关闭了listener
+	program.lock.Lock()
+	gen := program.gen
+	program.gen++
+	program.closeListener()
+	program.lock.Unlock()
+	if _, err := program.spawnProcess(gen, false); err != errStartCanceled {
+		t.Fatalf("got %v, want %v", err, errStartCanceled)
+	}
+	program.lock.Lock()
+	inited := program.listenerInited
+	program.lock.Unlock()
+	if inited {
+		t.Error("listener reopened after the program was stopped")
+	}
+}
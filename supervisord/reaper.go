@@ -0,0 +1,201 @@
+package supervisord
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 孤儿进程的扫描间隔，除此之外收到SIGCHLD时也会扫描
+const reapInterval = time.Second * 5
+
+// supergo成为subreaper之后，程序fork出的进程在父进程退出之后会被过继给supergo，
+// reaper负责回收这些进程，并根据进程组将其归属到对应的程序。
+// 通过exec.Cmd启动的子进程由exec.Cmd自己wait，reaper不能回收，否则exec.Cmd将拿不到退出状态
+type reaper struct {
+	// 启动子进程时持有读锁，扫描时持有写锁，保证扫描到的子进程要么已经登记，要么是孤儿进程
+	forkLock sync.RWMutex
+
+	lock     sync.Mutex
+	children map[int]bool     // 通过exec.Cmd启动，还没有被wait的子进程
+	groups   map[int]*Program // 进程组ID -> 程序，进程组ID即程序启动的进程的pid
+	orphans  map[int]int      // 正在回收的孤儿进程 -> 进程组ID
+}
+
+var reapOnce sync.Once
+
+var childReaper = &reaper{
+	children: make(map[int]bool),
+	groups:   make(map[int]*Program),
+	orphans:  make(map[int]int),
+}
+
+// 内核在创建子进程的线程（而不是进程）退出时发送pdeathsig，exec时除调用exec的线程之外的线程都会退出，
+// 因此所有的子进程都在同一个锁定的线程中创建，升级时也在该线程中exec，进程不会因为升级收到pdeathsig
+var forkThread = make(chan func())
+
+func init() {
+	go func() {
+		runtime.LockOSThread()
+		for f := range forkThread {
+			f()
+		}
+	}()
+}
+
+// 在创建子进程的线程中执行f
+func onForkThread(f func()) {
+	done := make(chan struct{})
+	forkThread <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+// 启动子进程并登记
+func startChild(cmd *exec.Cmd) error {
+	childReaper.forkLock.RLock()
+	defer childReaper.forkLock.RUnlock()
+	var err error
+	onForkThread(func() {
+		err = cmd.Start()
+	})
+	if err != nil {
+		return err
+	}
+	childReaper.lock.Lock()
+	childReaper.children[cmd.Process.Pid] = true
+	childReaper.lock.Unlock()
+	return nil
+}
+
+// 等待子进程退出并取消登记
+func waitChild(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	childReaper.lock.Lock()
+	delete(childReaper.children, cmd.Process.Pid)
+	childReaper.lock.Unlock()
+	return err
+}
+
+// 登记supergo升级之前启动的子进程
+func (r *reaper) addChild(pid int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.children[pid] = true
+}
+
+func (r *reaper) removeChild(pid int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.children, pid)
+}
+
+// 记录程序启动的进程组，该组中的孤儿进程将归属于该程序
+func (r *reaper) addGroup(pgid int, program *Program) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.groups[pgid] = program
+}
+
+// 程序的孤儿进程
+func (r *reaper) orphansOf(program *Program) []int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var pids []int
+	for pid, pgid := range r.orphans {
+		if r.groups[pgid] == program {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// 将supergo设置为subreaper，并开始回收孤儿进程
+func EnableSubreaper() error {
+	if err := setSubreaper(); err != nil {
+		return err
+	}
+	reapOnce.Do(func() {
+		go childReaper.run()
+	})
+	return nil
+}
+
+func (r *reaper) run() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGCHLD)
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		r.scan()
+		select {
+		case <-c:
+		case <-ticker.C:
+		}
+	}
+}
+
+// 找出没有登记的子进程，逐个wait回收
+func (r *reaper) scan() {
+	r.forkLock.Lock()
+	procs, err := listChildren()
+	if err != nil {
+		r.forkLock.Unlock()
+		return
+	}
+	r.lock.Lock()
+	r.forkLock.Unlock()
+	defer r.lock.Unlock()
+
+	alive := make(map[int]bool)
+	for _, p := range procs {
+		alive[p.pgid] = true
+		if r.children[p.pid] {
+			continue
+		}
+		if _, ok := r.orphans[p.pid]; ok {
+			continue
+		}
+		r.orphans[p.pid] = p.pgid
+		if prog := r.groups[p.pgid]; prog != nil {
+			prog.logger.Printf("adopt orphan process %d", p.pid)
+		}
+		go r.reap(p.pid)
+	}
+	// 进程组中的进程都已经退出之后，不再需要记录
+	for pgid := range r.groups {
+		if !alive[pgid] && !r.children[pgid] {
+			delete(r.groups, pgid)
+		}
+	}
+}
+
+func (r *reaper) reap(pid int) {
+	var ws syscall.WaitStatus
+	for {
+		_, err := syscall.Wait4(pid, &ws, 0, nil)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if prog := r.groups[r.orphans[pid]]; prog != nil {
+		prog.logger.Printf("orphan process %d exited with %d", pid, ws.ExitStatus())
+	}
+	delete(r.orphans, pid)
+}
+
+// supergo的子进程
+type childProc struct {
+	pid  int
+	pgid int
+}
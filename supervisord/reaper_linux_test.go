@@ -0,0 +1,85 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 启动会fork子进程的helper，返回子进程的pid
+func startForkHelper(t *testing.T, mode, sleep string, cfg *ProgramConfig) (*Program, int) {
+	dir, err := ioutil.TempDir("", "supergo-reaper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	pidFile := filepath.Join(dir, "pid")
+	cfg.Environment = EnvMap{
+		helperEnv:                   "1",
+		helperModeEnv:               mode,
+		helperPidEnv:                pidFile,
+		"SUPERGO_TEST_HELPER_SLEEP": sleep,
+	}
+	prog, err := NewProgram(mode, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		data, _ := ioutil.ReadFile(pidFile)
+		if pid, err := strconv.Atoi(string(data)); err == nil {
+			return prog, pid
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	t.Fatal("helper did not write pid file")
+	return nil, 0
+}
+
+// 进程不存在或者已经是僵尸进程
+func processGone(pid int) bool {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	stat := string(data)
+	return strings.HasPrefix(strings.TrimSpace(stat[strings.LastIndex(stat, ")")+1:]), "Z")
+}
+
+func Test_KillGroup(t *testing.T) {
+	if err := EnableSubreaper(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := helperConfig()
+	cfg.KillGroup = true
+	cfg.StopTimeout = 2
+	prog, pid := startForkHelper(t, "fork", "60", cfg)
+	if err := prog.StopProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool { return processGone(pid) }, "child of the process group survived stop")
+}
+
+func Test_ReapOrphans(t *testing.T) {
+	if err := EnableSubreaper(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	prog, pid := startForkHelper(t, "orphan", "2", cfg)
+	waitFor(t, time.Second*3, func() bool {
+		orphans := prog.Status().Orphans
+		return len(orphans) == 1 && orphans[0] == pid
+	}, "orphan is not listed in status")
+	// sleep退出之后应该被回收，而不是成为僵尸进程
+	waitFor(t, time.Second*10, func() bool {
+		_, err := os.Stat("/proc/" + strconv.Itoa(pid))
+		return os.IsNotExist(err) && len(prog.Status().Orphans) == 0
+	}, "orphan is not reaped")
+}
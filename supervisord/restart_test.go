@@ -0,0 +1,134 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 等待notify模式的进程写入新的pid
+func waitPidFile(t *testing.T, file string, old ...int) int {
+	var pid int
+	waitFor(t, time.Second*5, func() bool {
+		data, _ := ioutil.ReadFile(file)
+		pid, _ = strconv.Atoi(string(data))
+		for _, p := range old {
+			if pid == p {
+				return false
+			}
+		}
+		return pid != 0
+	}, "process is not started")
+	return pid
+}
+
+// 进程已经退出并且被回收
+func processExited(pid int) func() bool {
+	return func() bool {
+		return syscall.Kill(pid, 0) == syscall.ESRCH
+	}
+}
+
+// 重启时新的进程发送READY=1之后才停止老的进程，超过ready_timeout没有就绪时停止新的进程，
+// 老的进程继续运行，状态为RestartFailed
+func Test_RestartWaitsForReady(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+	cfg := notifyConfig("")
+	cfg.Environment[helperPidEnv] = pidFile
+	cfg.ReadyTimeout = 2
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	program, err := supervisor.AddProgram("restart", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	pid1 := waitPidFile(t, pidFile)
+	syscall.Kill(pid1, syscall.SIGUSR1)
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().State == ProcessStateRunning
+	}, "not running after READY=1")
+
+	// 新的进程一直没有就绪
+	if err := program.RestartProess(); err != ErrProcessNotReady {
+		t.Fatalf("got %v, want ErrProcessNotReady", err)
+	}
+	if s := program.Status(); s.State != ProcessStateRestartFailed || s.Pid != pid1 {
+		t.Fatalf("got %s with pid %d after restart failed", s.State, s.Pid)
+	}
+	pid2 := waitPidFile(t, pidFile, pid1)
+	waitFor(t, time.Second*5, processExited(pid2), "new process is not stopped")
+	if syscall.Kill(pid1, 0) != nil {
+		t.Fatal("old process is stopped")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- program.RestartProess() }()
+	pid3 := waitPidFile(t, pidFile, pid1, pid2)
+	time.Sleep(time.Millisecond * 500)
+	if syscall.Kill(pid1, 0) != nil {
+		t.Fatal("old process is stopped before the new one is ready")
+	}
+	syscall.Kill(pid3, syscall.SIGUSR1)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s := program.Status(); s.State != ProcessStateRunning || s.Pid != pid3 {
+		t.Fatalf("got %s with pid %d after restart", s.State, s.Pid)
+	}
+	waitFor(t, time.Second*5, processExited(pid1), "old process is not stopped")
+}
+
+// 重启失败时老的进程也已经退出，按照auto_restart进入Backoff并重试，而不是停留在Exited
+func Test_RestartFailedOldExited(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cfg := notifyConfig("")
+	cfg.Environment[helperPidEnv] = pidFile
+	cfg.ReadyTimeout = 2
+	program, err := NewProgram("restart-exited", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer program.StopProcess()
+	pid1 := waitPidFile(t, pidFile)
+	syscall.Kill(pid1, syscall.SIGUSR1)
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().State == ProcessStateRunning
+	}, "not running after READY=1")
+
+	done := make(chan error, 1)
+	go func() { done <- program.RestartProess() }()
+	pid2 := waitPidFile(t, pidFile, pid1)
+	syscall.Kill(pid1, syscall.SIGKILL)
+	if err := <-done; err != ErrProcessNotReady {
+		t.Fatalf("got %v, want ErrProcessNotReady", err)
+	}
+	waitFor(t, time.Second*5, processExited(pid2), "new process is not stopped")
+	// 重试启动的进程
+	waitPidFile(t, pidFile, pid1, pid2)
+	if s := program.Status().State; s != ProcessStateStarting {
+		t.Fatalf("got %s after retry", s)
+	}
+	history := program.History()
+	found := false
+	for _, h := range history {
+		found = found || h.To == ProcessStateBackoff
+	}
+	if !found {
+		t.Fatalf("no Backoff in history %+v", history)
+	}
+}
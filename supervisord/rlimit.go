@@ -0,0 +1,220 @@
+package supervisord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 配置了rlimits、umask、调度优先级等或者使用cgroup时，supergo先以该环境变量启动自己，设置好之后再exec真正的指令，
+// 这些设置在进程运行之前就已经生效，不影响supergo自己，并且进程的pid不变，信号可以直接发送给进程
+const shimEnv = "SUPERGO_SHIM"
+
+// 进程的资源限制，可以配置为数字、"unlimited"或者"soft:hard"
+type Rlimit struct {
+	Cur uint64
+	Max uint64
+}
+
+// toml中的数字和字符串都按文本解析
+func (r *Rlimit) UnmarshalTOML(v interface{}) error {
+	return r.UnmarshalText([]byte(fmt.Sprint(v)))
+}
+
+func (r *Rlimit) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	parts := strings.SplitN(s, ":", 2)
+	cur, err := parseRlimitValue(parts[0])
+	if err != nil {
+		return err
+	}
+	max := cur
+	if len(parts) == 2 {
+		if max, err = parseRlimitValue(parts[1]); err != nil {
+			return err
+		}
+	}
+	if cur > max {
+		return fmt.Errorf("invalid rlimit %q: soft limit is greater than hard limit", s)
+	}
+	r.Cur, r.Max = cur, max
+	return nil
+}
+
+func (r Rlimit) MarshalText() ([]byte, error) {
+	if r.Cur == r.Max {
+		return []byte(formatRlimitValue(r.Cur)), nil
+	}
+	return []byte(formatRlimitValue(r.Cur) + ":" + formatRlimitValue(r.Max)), nil
+}
+
+func parseRlimitValue(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "unlimited" || s == "infinity" {
+		return rlimInfinity, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rlimit value %q", s)
+	}
+	return v, nil
+}
+
+func formatRlimitValue(v uint64) string {
+	if v == rlimInfinity {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// 检查资源限制的名称，非root时不能提高hard limit
+func (cfg *ProgramConfig) checkRlimits() error {
+	names := make([]string, 0, len(cfg.Rlimits))
+	for name := range cfg.Rlimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res, ok := rlimitResources[name]
+		if !ok {
+			return fmt.Errorf("unknown rlimit %q", name)
+		}
+		if os.Geteuid() == 0 {
+			continue
+		}
+		var sys syscall.Rlimit
+		if err := syscall.Getrlimit(res, &sys); err != nil {
+			return err
+		}
+		if cur := fromSysRlimit(sys); cfg.Rlimits[name].Max > cur.Max {
+			return fmt.Errorf("rlimit %s: supergo is not permitted to raise hard limit above %s", name, formatRlimitValue(cur.Max))
+		}
+	}
+	return nil
+}
+
+// shim进程需要的信息
+type shimConfig struct {
+	Path      string                 `json:"path"`
+	Rlimits   map[int]syscall.Rlimit `json:"rlimits"`
+	Cred      *syscall.Credential    `json:"cred,omitempty"`
+	Pdeathsig int                    `json:"pdeathsig,omitempty"`
+	Cgroup    string                 `json:"cgroup,omitempty"`
+	Umask     int                    `json:"umask"` // 为-1时不设置
+	Sched     *schedConfig           `json:"sched,omitempty"`
+}
+
+// 需要在exec之前设置的属性
+func (cfg *ProgramConfig) needShim() bool {
+	return len(cfg.Rlimits) != 0 || cfg.Umask != "" || cfg.schedConfig() != nil
+}
+
+// 将指令改为通过shim启动，shim在设置资源限制并加入cgroup之后才切换用户，否则没有权限，
+// 在exec之前加入cgroup，进程fork出的子进程也都在cgroup中
+func (program *Program) useShim(cmd *exec.Cmd, cred *syscall.Credential, mask int, cgroupDir string) error {
+	path, err := filepath.Abs(cmd.Path)
+	if err != nil {
+		return err
+	}
+	shim := &shimConfig{
+		Path:    path,
+		Rlimits: make(map[int]syscall.Rlimit),
+		Cred:    cred,
+		Cgroup:  cgroupDir,
+		Umask:   mask,
+		Sched:   program.cfg.schedConfig(),
+	}
+	for name, limit := range program.cfg.Rlimits {
+		shim.Rlimits[rlimitResources[name]] = toSysRlimit(limit)
+	}
+	if cmd.SysProcAttr != nil {
+		cmd.SysProcAttr.Credential = nil
+	}
+	if program.cfg.Pdeathsig != "" {
+		sig, _ := parseSignal(program.cfg.Pdeathsig)
+		shim.Pdeathsig = int(sig)
+	}
+	data, err := json.Marshal(shim)
+	if err != nil {
+		return err
+	}
+	cmd.Path = selfExe()
+	cmd.Env = append(cmd.Env, shimEnv+"="+string(data))
+	return nil
+}
+
+func init() {
+	if data := os.Getenv(shimEnv); data != "" {
+		runShim(data)
+	}
+}
+
+// 设置资源限制和用户之后exec真正的指令，不会返回
+func runShim(data string) {
+	// 切换用户只对当前线程生效，随后的exec会替换整个进程
+	runtime.LockOSThread()
+	os.Unsetenv(shimEnv)
+	fail := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "supergo shim: "+format+"\n", args...)
+		os.Exit(127)
+	}
+	shim := new(shimConfig)
+	if err := json.Unmarshal([]byte(data), shim); err != nil {
+		fail("%s", err.Error())
+	}
+	for res, limit := range shim.Rlimits {
+		limit := limit
+		if err := syscall.Setrlimit(res, &limit); err != nil {
+			fail("setrlimit %d: %s", res, err.Error())
+		}
+	}
+	// 加入cgroup失败时进程仍然可以运行，只是没有资源限制
+	if shim.Cgroup != "" {
+		procs := filepath.Join(shim.Cgroup, "cgroup.procs")
+		if err := ioutil.WriteFile(procs, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "supergo shim: join cgroup %s: %s\n", shim.Cgroup, err.Error())
+		}
+	}
+	// 在切换用户之前设置，以普通用户运行的进程也可以降低nice和oom_score_adj
+	if shim.Sched != nil {
+		shim.Sched.apply()
+	}
+	if cred := shim.Cred; cred != nil {
+		if err := syscall.Setgroups(intSlice(cred.Groups)); err != nil {
+			fail("setgroups: %s", err.Error())
+		}
+		if err := syscall.Setgid(int(cred.Gid)); err != nil {
+			fail("setgid: %s", err.Error())
+		}
+		if err := syscall.Setuid(int(cred.Uid)); err != nil {
+			fail("setuid: %s", err.Error())
+		}
+	}
+	// 切换用户之后内核会清除pdeathsig，需要重新设置
+	if shim.Pdeathsig != 0 {
+		if err := setSelfPdeathsig(syscall.Signal(shim.Pdeathsig)); err != nil {
+			fail("pdeathsig: %s", err.Error())
+		}
+	}
+	if shim.Umask >= 0 {
+		syscall.Umask(shim.Umask)
+	}
+	err := syscall.Exec(shim.Path, os.Args, os.Environ())
+	fail("exec %s: %s", shim.Path, err.Error())
+}
+
+func intSlice(u []uint32) []int {
+	s := make([]int, 0, len(u))
+	for _, v := range u {
+		s = append(s, int(v))
+	}
+	return s
+}
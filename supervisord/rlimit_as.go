@@ -0,0 +1,11 @@
+//go:build darwin || freebsd || netbsd || dragonfly || solaris || aix
+// +build darwin freebsd netbsd dragonfly solaris aix
+
+package supervisord
+
+import "syscall"
+
+// openbsd等系统没有限制地址空间的RLIMIT_AS
+func init() {
+	rlimitResources["as"] = syscall.RLIMIT_AS
+}
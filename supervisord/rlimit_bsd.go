@@ -0,0 +1,15 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+// +build darwin freebsd netbsd openbsd dragonfly
+
+package supervisord
+
+// syscall包中没有定义，取值来自4.4BSD的sys/resource.h，这些系统都相同
+const (
+	rlimitMemlock = 6
+	rlimitNproc   = 7
+)
+
+func init() {
+	rlimitResources["memlock"] = rlimitMemlock
+	rlimitResources["nproc"] = rlimitNproc
+}
@@ -0,0 +1,15 @@
+//go:build freebsd || dragonfly
+// +build freebsd dragonfly
+
+package supervisord
+
+import "syscall"
+
+// FreeBSD和DragonFly的syscall.Rlimit字段为int64，rlimInfinity在int64的范围内
+func toSysRlimit(r Rlimit) syscall.Rlimit {
+	return syscall.Rlimit{Cur: int64(r.Cur), Max: int64(r.Max)}
+}
+
+func fromSysRlimit(r syscall.Rlimit) Rlimit {
+	return Rlimit{Cur: uint64(r.Cur), Max: uint64(r.Max)}
+}
@@ -0,0 +1,11 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package supervisord
+
+// syscall包中没有定义，取值来自include/uapi/asm-generic/resource.h
+const (
+	rlimitRSS     = 5
+	rlimitNproc   = 6
+	rlimitMemlock = 8
+)
@@ -0,0 +1,12 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+package supervisord
+
+// mips的资源编号与其他架构不同，取值来自arch/mips/include/uapi/asm/resource.h
+const (
+	rlimitRSS     = 7
+	rlimitNproc   = 8
+	rlimitMemlock = 9
+)
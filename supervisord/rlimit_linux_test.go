@@ -0,0 +1,64 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// 资源编号与架构有关，syscall包中定义了的必须相同，其余的与/proc/self/limits的行号对比，
+// 内核按资源编号的顺序输出每一行
+func Test_RlimitResources(t *testing.T) {
+	sys := map[string]int{
+		"cpu":    syscall.RLIMIT_CPU,
+		"fsize":  syscall.RLIMIT_FSIZE,
+		"data":   syscall.RLIMIT_DATA,
+		"stack":  syscall.RLIMIT_STACK,
+		"core":   syscall.RLIMIT_CORE,
+		"nofile": syscall.RLIMIT_NOFILE,
+		"as":     syscall.RLIMIT_AS,
+	}
+	for name, res := range sys {
+		if rlimitResources[name] != res {
+			t.Errorf("%s: got %d, want %d", name, rlimitResources[name], res)
+		}
+	}
+
+	data, err := ioutil.ReadFile("/proc/self/limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	descs := map[string]string{
+		"Max cpu time":          "cpu",
+		"Max file size":         "fsize",
+		"Max data size":         "data",
+		"Max stack size":        "stack",
+		"Max core file size":    "core",
+		"Max resident set":      "rss",
+		"Max processes":         "nproc",
+		"Max open files":        "nofile",
+		"Max locked memory":     "memlock",
+		"Max address space":     "as",
+		"Max file locks":        "locks",
+		"Max pending signals":   "sigpending",
+		"Max msgqueue size":     "msgqueue",
+		"Max nice priority":     "nice",
+		"Max realtime priority": "rtprio",
+	}
+	found := 0
+	// 第一行是表头
+	for res, line := range strings.Split(string(data), "\n")[1:] {
+		for desc, name := range descs {
+			if strings.HasPrefix(line, desc+"  ") {
+				found++
+				if rlimitResources[name] != res {
+					t.Errorf("%s: got %d, want %d", name, rlimitResources[name], res)
+				}
+			}
+		}
+	}
+	if found != len(rlimitResources) {
+		t.Errorf("found %d limits in /proc/self/limits, want %d", found, len(rlimitResources))
+	}
+}
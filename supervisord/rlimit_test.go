@@ -0,0 +1,84 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func Test_ParseRlimit(t *testing.T) {
+	var cfg struct {
+		Rlimits map[string]Rlimit `toml:"rlimits"`
+	}
+	_, err := toml.Decode(`rlimits = {nofile = 65536, core = "unlimited", nproc = "1024:2048"}`, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]Rlimit{
+		"nofile": {65536, 65536},
+		"core":   {rlimInfinity, rlimInfinity},
+		"nproc":  {1024, 2048},
+	}
+	for name, want := range expect {
+		if got, ok := cfg.Rlimits[name]; !ok || got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	if text, _ := cfg.Rlimits["nproc"].MarshalText(); string(text) != "1024:2048" {
+		t.Errorf("marshal: got %s", text)
+	}
+
+	for _, s := range []string{"", "abc", "10:5", "-1", "1:2:3"} {
+		var r Rlimit
+		if err := r.UnmarshalText([]byte(s)); err == nil {
+			t.Errorf("%q: expect error", s)
+		}
+	}
+	if err := (&ProgramConfig{Rlimits: map[string]Rlimit{"nofiles": {1, 1}}}).checkRlimits(); err == nil {
+		t.Error("expect error for unknown rlimit")
+	}
+}
+
+// 通过shim设置的资源限制，在进程启动时就已经生效
+func Test_RlimitShim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-rlimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "limits")
+
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "rlimit", helperPidEnv: out}
+	cfg.Rlimits = map[string]Rlimit{"nofile": {512, 1000}, "core": {0, 1024}}
+	if err := cfg.check(); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := NewProgram("rlimit", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer prog.StopProcess()
+
+	var data []byte
+	waitFor(t, time.Second*5, func() bool {
+		data, _ = ioutil.ReadFile(out)
+		return len(data) != 0
+	}, "helper did not write limits")
+	// Go程序启动时会将nofile的soft limit提高到hard limit，所以只检查hard limit
+	if string(data) != "1000 0 1024" {
+		t.Fatalf("got limits %q, want %q", data, "1000 0 1024")
+	}
+	env, _ := prog.Environ()
+	if _, ok := env[shimEnv]; ok {
+		t.Fatal("shim env is exposed")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !freebsd && !dragonfly
+// +build !freebsd,!dragonfly
+
+package supervisord
+
+import "syscall"
+
+func toSysRlimit(r Rlimit) syscall.Rlimit {
+	return syscall.Rlimit{Cur: r.Cur, Max: r.Max}
+}
+
+func fromSysRlimit(r syscall.Rlimit) Rlimit {
+	return Rlimit{Cur: r.Cur, Max: r.Max}
+}
@@ -0,0 +1,127 @@
+package supervisord
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 等待重启的进程就绪时，检查程序状态的间隔
+const rollingPollInterval = time.Millisecond * 100
+
+// 滚动重启的结果
+type RollingReport struct {
+	Restarted []string          `json:"restarted,omitempty"`
+	Failed    map[string]string `json:"failed,omitempty"`
+	Skipped   []string          `json:"skipped,omitempty"` // 前面的批次失败之后没有重启的程序
+}
+
+// 分批重启，每批同时重启batch个程序，等待这一批全部就绪之后，暂停pause再重启下一批，
+// 某一批中有程序重启失败时不再继续，已经重启的程序不会回滚
+func (supervisor *Supervisor) RollingRestart(target string, batch int, pause time.Duration) (*RollingReport, error) {
+	progs, err := supervisor.resolveTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	setOperatorStopped(progs, false)
+	return supervisor.rollingRestart(progs, batch, pause)
+}
+
+func (supervisor *Supervisor) rollingRestart(progs []*Program, batch int, pause time.Duration) (*RollingReport, error) {
+	if batch <= 0 {
+		batch = 1
+	}
+	report := new(RollingReport)
+	for i := 0; i < len(progs); i += batch {
+		if i > 0 && pause > 0 {
+			time.Sleep(pause)
+		}
+		end := i + batch
+		if end > len(progs) {
+			end = len(progs)
+		}
+		errs := restartBatch(progs[i:end])
+		for _, prog := range progs[i:end] {
+			if err := errs[prog.Name]; err != nil {
+				if report.Failed == nil {
+					report.Failed = make(map[string]string)
+				}
+				report.Failed[prog.Name] = err.Error()
+			} else {
+				report.Restarted = append(report.Restarted, prog.Name)
+			}
+		}
+		if len(report.Failed) != 0 {
+			for _, prog := range progs[end:] {
+				report.Skipped = append(report.Skipped, prog.Name)
+			}
+			return report, report.err()
+		}
+	}
+	return report, nil
+}
+
+func restartBatch(progs []*Program) map[string]error {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]error)
+	for _, prog := range progs {
+		wg.Add(1)
+		go func(prog *Program) {
+			defer wg.Done()
+			if err := prog.RestartAndWait(); err != nil {
+				lock.Lock()
+				errs[prog.Name] = err
+				lock.Unlock()
+			}
+		}(prog)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (report *RollingReport) failedNames() []string {
+	names := make([]string, 0, len(report.Failed))
+	for name := range report.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (report *RollingReport) err() error {
+	var failed []string
+	for _, name := range report.failedNames() {
+		failed = append(failed, name+": "+report.Failed[name])
+	}
+	return fmt.Errorf("rolling restart aborted, %s", strings.Join(failed, "; "))
+}
+
+// 重启并等待新的进程就绪，stop_before_restart时RestartProess在新进程启动之前就会返回
+func (program *Program) RestartAndWait() error {
+	if err := program.RestartProess(); err != nil {
+		return err
+	}
+	return program.waitRunning()
+}
+
+// 等待程序Running，最多等待ready_timeout，Backoff时仍在重试，继续等待，程序已经停止或者失败时返回错误
+func (program *Program) waitRunning() error {
+	deadline := time.Now().Add(time.Second * time.Duration(program.cfg.ReadyTimeout))
+	for {
+		state := program.Status().State
+		switch state {
+		case ProcessStateRunning:
+			return nil
+		case ProcessStateStarting, ProcessStateBackoff:
+		default:
+			return fmt.Errorf("process is %s", state)
+		}
+		if time.Now().After(deadline) {
+			return ErrProcessNotReady
+		}
+		time.Sleep(rollingPollInterval)
+	}
+}
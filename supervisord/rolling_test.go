@@ -0,0 +1,89 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func startInstances(t *testing.T, cfg *ProgramConfig) *Supervisor {
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	for name, c := range expandInstances(map[string]*ProgramConfig{"web": cfg}) {
+		if _, err := supervisor.AddProgram(name, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := supervisor.StartProgram("web"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		for _, s := range supervisor.GetStatus() {
+			if s.State != ProcessStateRunning {
+				return false
+			}
+		}
+		return true
+	}, "instances are not running")
+	return supervisor
+}
+
+func Test_RollingRestart(t *testing.T) {
+	cfg := helperConfig()
+	cfg.Environment = EnvMap{helperEnv: "1"}
+	cfg.NumProcs = 3
+	cfg.StopBeforeRestart = true
+	supervisor := startInstances(t, cfg)
+	defer supervisor.Exit()
+
+	pids := make(map[string]int)
+	for _, s := range supervisor.GetStatus() {
+		pids[s.Name] = s.Pid
+	}
+	report, err := supervisor.RollingRestart("web", 2, time.Millisecond*100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web:0", "web:1", "web:2"}; !reflect.DeepEqual(report.Restarted, want) {
+		t.Fatalf("got restarted %v, want %v", report.Restarted, want)
+	}
+	// stop_before_restart时也要等到新的进程就绪才返回
+	for _, s := range supervisor.GetStatus() {
+		if s.State != ProcessStateRunning || s.Pid == pids[s.Name] {
+			t.Errorf("%s is %s with pid %d", s.Name, s.State, s.Pid)
+		}
+	}
+}
+
+// 一批中有程序重启失败时，之后的批次不再重启
+func Test_RollingRestartAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-rolling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "marker", helperPidEnv: marker}
+	cfg.NumProcs = 3
+	supervisor := startInstances(t, cfg)
+	defer supervisor.Exit()
+
+	ioutil.WriteFile(marker+".1", nil, 0644)
+	report, err := supervisor.RollingRestart("web", 1, 0)
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	if !reflect.DeepEqual(report.Restarted, []string{"web:0"}) || report.Failed["web:1"] == "" ||
+		!reflect.DeepEqual(report.Skipped, []string{"web:2"}) {
+		t.Fatalf("got report %+v", report)
+	}
+	// 重启失败时老的进程继续运行
+	if s := supervisor.GetProgram("web:1").Status(); s.State != ProcessStateRestartFailed {
+		t.Errorf("web:1 is %s", s.State)
+	}
+}
@@ -0,0 +1,132 @@
+package supervisord
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cpu_affinity最多支持的CPU数量
+const maxCPUs = 1024
+
+// io_class的名称，同ionice
+var ioClasses = map[string]int{
+	"none":        0,
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// 解析CPU列表，格式同taskset -c，如"0-3,6"
+func parseCPUList(s string) ([]int, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q", s)
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid cpu list %q", s)
+			}
+		}
+		if lo < 0 || hi < lo || hi >= maxCPUs {
+			return nil, fmt.Errorf("invalid cpu list %q", s)
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			set[cpu] = true
+		}
+	}
+	cpus := make([]int, 0, len(set))
+	for cpu := range set {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+func (cfg *ProgramConfig) checkSched() error {
+	if cfg.Nice < -20 || cfg.Nice > 19 {
+		return errors.New("nice must be between -20 and 19")
+	}
+	if cfg.OOMScoreAdj < -1000 || cfg.OOMScoreAdj > 1000 {
+		return errors.New("oom_score_adj must be between -1000 and 1000")
+	}
+	if cfg.IOPriority < 0 || cfg.IOPriority > 7 {
+		return errors.New("io_priority must be between 0 and 7")
+	}
+	if _, ok := ioClasses[cfg.IOClass]; !ok && cfg.IOClass != "" {
+		return fmt.Errorf("unknown io_class %q", cfg.IOClass)
+	}
+	if cfg.CPUAffinity != "" {
+		if _, err := parseCPUList(cfg.CPUAffinity); err != nil {
+			return err
+		}
+	}
+	if runtime.GOOS != "linux" && (cfg.CPUAffinity != "" || cfg.IOClass != "" || cfg.IOPriority != 0 || cfg.OOMScoreAdj != 0) {
+		return errors.New("cpu_affinity, io_class, io_priority and oom_score_adj are only supported on linux")
+	}
+	return nil
+}
+
+// 由shim在exec之前设置的调度属性
+type schedConfig struct {
+	Nice        int   `json:"nice,omitempty"`
+	CPUs        []int `json:"cpus,omitempty"`
+	IOClass     int   `json:"io_class"` // 为-1时不设置IO优先级
+	IOPriority  int   `json:"io_priority,omitempty"`
+	OOMScoreAdj int   `json:"oom_score_adj,omitempty"`
+}
+
+// 没有配置调度属性时返回nil
+func (cfg *ProgramConfig) schedConfig() *schedConfig {
+	sched := &schedConfig{Nice: cfg.Nice, IOClass: -1, IOPriority: cfg.IOPriority, OOMScoreAdj: cfg.OOMScoreAdj}
+	if cfg.CPUAffinity != "" {
+		sched.CPUs, _ = parseCPUList(cfg.CPUAffinity)
+	}
+	if class, ok := ioClasses[cfg.IOClass]; ok {
+		sched.IOClass = class
+	} else if cfg.IOPriority != 0 {
+		sched.IOClass = ioClasses["best-effort"]
+	}
+	if sched.Nice == 0 && sched.CPUs == nil && sched.IOClass < 0 && sched.OOMScoreAdj == 0 {
+		return nil
+	}
+	return sched
+}
+
+// 在shim中exec之前设置调度优先级、CPU亲和性、IO优先级和oom_score_adj，进程之后创建的线程和子进程都会继承。
+// nice等是线程的属性，shim锁定了执行exec的线程，只需要设置当前线程。设置失败时进程仍然运行
+func (sched *schedConfig) apply() {
+	warn := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "supergo shim: "+format+"\n", args...)
+	}
+	if sched.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, sched.Nice); err != nil {
+			warn("set nice: %s", err.Error())
+		}
+	}
+	if sched.CPUs != nil {
+		if err := setCPUAffinity(0, sched.CPUs); err != nil {
+			warn("set cpu affinity: %s", err.Error())
+		}
+	}
+	if sched.IOClass >= 0 {
+		if err := setIOPriority(0, sched.IOClass, sched.IOPriority); err != nil {
+			warn("set io priority: %s", err.Error())
+		}
+	}
+	if sched.OOMScoreAdj != 0 {
+		if err := setOOMScoreAdj(os.Getpid(), sched.OOMScoreAdj); err != nil {
+			warn("set oom_score_adj: %s", err.Error())
+		}
+	}
+}
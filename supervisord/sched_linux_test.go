@@ -0,0 +1,83 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("3, 0-1,1")
+	if err != nil || len(cpus) != 3 || cpus[0] != 0 || cpus[1] != 1 || cpus[2] != 3 {
+		t.Fatalf("got %v %v", cpus, err)
+	}
+	for _, s := range []string{"", "a", "3-1", "-1", "0-1024"} {
+		if _, err := parseCPUList(s); err == nil {
+			t.Errorf("%q: expect error", s)
+		}
+	}
+	for _, cfg := range []*ProgramConfig{{Nice: 20}, {OOMScoreAdj: -1001}, {IOClass: "fast"}, {IOPriority: 8}} {
+		if err := cfg.checkSched(); err == nil {
+			t.Errorf("%+v: expect error", cfg)
+		}
+	}
+}
+
+// 提高nice和oom_score_adj不需要root权限
+func Test_ApplySched(t *testing.T) {
+	cfg := helperConfig()
+	cfg.AutoRestart = false
+	cfg.Environment = EnvMap{helperEnv: "1"}
+	cfg.Nice = 5
+	cfg.CPUAffinity = "0"
+	cfg.IOClass = "idle"
+	cfg.OOMScoreAdj = 500
+	if err := cfg.check(); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := NewProgram("sched", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	defer prog.StopProcess()
+
+	var pid int
+	waitFor(t, time.Second*5, func() bool {
+		pid = prog.Status().Pid
+		return pid != 0
+	}, "process did not start")
+	// 在exec之前设置，进程启动时创建的所有线程都会继承
+	tasks, err := ioutil.ReadDir("/proc/" + strconv.Itoa(pid) + "/task")
+	if err != nil || len(tasks) < 2 {
+		t.Fatalf("got %d threads %v", len(tasks), err)
+	}
+	for _, task := range tasks {
+		dir := "/proc/" + strconv.Itoa(pid) + "/task/" + task.Name()
+		data, _ := ioutil.ReadFile(dir + "/stat")
+		fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+2:]))
+		// 去掉pid和comm之后，nice为第17个字段
+		if fields[16] != "5" {
+			t.Errorf("thread %s: got nice %s, want 5", task.Name(), fields[16])
+		}
+		if data, _ := ioutil.ReadFile(dir + "/status"); !strings.Contains(string(data), "Cpus_allowed_list:\t0\n") {
+			t.Errorf("thread %s: cpu affinity is not set", task.Name())
+		}
+	}
+	if data, _ := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/oom_score_adj"); strings.TrimSpace(string(data)) != "500" {
+		t.Errorf("got oom_score_adj %q, want 500", data)
+	}
+	data, _ := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if !strings.Contains(string(data), "Cpus_allowed_list:\t0\n") {
+		t.Errorf("cpu affinity is not set:\n%s", data)
+	}
+	prio, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(pid), 0)
+	if errno != 0 || prio>>ioprioClassShift != 3 {
+		t.Errorf("got io priority %d %v, want idle", prio, errno)
+	}
+}
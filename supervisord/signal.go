@@ -0,0 +1,73 @@
+package supervisord
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"ALRM":  syscall.SIGALRM,
+	"WINCH": syscall.SIGWINCH,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"TSTP":  syscall.SIGTSTP,
+}
+
+// 解析信号量，支持TERM、SIGTERM以及数字的形式，数字不能超过系统最大的信号
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if n, err := strconv.Atoi(name); err == nil && n > 0 && n <= maxSignal {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(name, "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("invalid signal %q", name)
+}
+
+// 停止进程时的一步：发送信号后等待进程退出的时间
+type StopStep struct {
+	Signal string `toml:"signal" json:"signal"`
+	Wait   int    `toml:"wait" json:"wait"` // 等待的秒数，为0时使用stop_timeout
+}
+
+type stopStep struct {
+	signal syscall.Signal
+	wait   time.Duration
+}
+
+// 停止进程时依次执行的步骤，配置了stop_signals时以其为准，否则发送stop_signal并等待stop_timeout，
+// 所有步骤执行完进程仍未退出时，将强行KILL
+func (cfg *ProgramConfig) stopSteps() ([]stopStep, error) {
+	steps := cfg.StopSignals
+	if len(steps) == 0 {
+		steps = []*StopStep{{Signal: cfg.StopSignal}}
+	}
+	var result []stopStep
+	for _, step := range steps {
+		name := step.Signal
+		if name == "" {
+			name = "TERM"
+		}
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		wait := step.Wait
+		if wait <= 0 {
+			wait = cfg.StopTimeout
+		}
+		result = append(result, stopStep{signal: sig, wait: time.Second * time.Duration(wait)})
+	}
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package supervisord
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ParseSignal(t *testing.T) {
+	cases := map[string]syscall.Signal{
+		"TERM":    syscall.SIGTERM,
+		"sigquit": syscall.SIGQUIT,
+		"SIGUSR2": syscall.SIGUSR2,
+		"9":       syscall.SIGKILL,
+	}
+	for name, want := range cases {
+		sig, err := parseSignal(name)
+		if err != nil || sig != want {
+			t.Errorf("parse %s: got %v %v, want %v", name, sig, err, want)
+		}
+	}
+	for _, name := range []string{"NOPE", "0", "999", strconv.Itoa(maxSignal + 1)} {
+		if _, err := parseSignal(name); err == nil {
+			t.Errorf("parse %s should fail", name)
+		}
+	}
+}
+
+func Test_StopSteps(t *testing.T) {
+	cfg := &ProgramConfig{
+		StopTimeout: 10,
+		StopSignals: []*StopStep{
+			{Signal: "USR2", Wait: 5},
+			{Signal: "TERM"},
+		},
+	}
+	steps, err := cfg.stopSteps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].signal != syscall.SIGUSR2 || steps[1].wait.Seconds() != 10 {
+		t.Errorf("unexpected steps %+v", steps)
+	}
+}
+
+// 每收到一个信号，向helperPidEnv文件追加一行"信号 纳秒时间"，第一行为ready
+func signalHelper() {
+	f, err := os.OpenFile(os.Getenv(helperPidEnv), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		os.Exit(2)
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1, syscall.SIGUSR2)
+	fmt.Fprintln(f, "ready")
+	for sig := range c {
+		fmt.Fprintf(f, "%d %d\n", sig.(syscall.Signal), time.Now().UnixNano())
+	}
+}
+
+// 子进程记录的收到信号的时间间隔与发送的间隔之间允许的误差
+const signalTolerance = time.Millisecond * 100
+
+// 依次发送stop_signals中的信号，每一步等待其wait之后才发送下一个，最后KILL；
+// 没有配置stop_signals时发送stop_signal，等待stop_timeout
+func Test_StopSignals(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		setup   func(cfg *ProgramConfig)
+		signals []syscall.Signal
+		waits   []time.Duration
+	}{
+		{"stop_signal", func(cfg *ProgramConfig) {
+			cfg.StopSignal = "USR1"
+			cfg.StopTimeout = 1
+		}, []syscall.Signal{syscall.SIGUSR1}, []time.Duration{time.Second}},
+		{"stop_signals", func(cfg *ProgramConfig) {
+			cfg.StopSignals = []*StopStep{{Signal: "USR2", Wait: 1}, {Signal: "INT", Wait: 2}}
+		}, []syscall.Signal{syscall.SIGUSR2, syscall.SIGINT}, []time.Duration{time.Second, time.Second * 2}},
+	} {
+		out := filepath.Join(t.TempDir(), "signals")
+		cfg := helperConfig()
+		cfg.AutoRestart = false
+		cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: "signals", helperPidEnv: out}
+		c.setup(cfg)
+		prog, err := NewProgram("signals", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := prog.StartProcess(); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, time.Second*5, func() bool {
+			data, _ := ioutil.ReadFile(out)
+			return len(data) != 0
+		}, "helper is not ready")
+
+		pid := prog.Status().Pid
+		start := time.Now()
+		if err := prog.StopProcess(); err != nil {
+			t.Fatal(err)
+		}
+		// 所有步骤之后仍然没有退出的进程被KILL
+		if err := syscall.Kill(pid, 0); err == nil {
+			t.Errorf("%s: process %d is still running", c.name, pid)
+		}
+		var total time.Duration
+		for _, wait := range c.waits {
+			total += wait
+		}
+		if elapsed := time.Since(start); elapsed < total {
+			t.Errorf("%s: stopped after %s, want at least %s", c.name, elapsed, total)
+		}
+
+		data, _ := ioutil.ReadFile(out)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")[1:]
+		if len(lines) != len(c.signals) {
+			t.Fatalf("%s: got signals %q, want %v", c.name, lines, c.signals)
+		}
+		last := start.UnixNano()
+		for i, line := range lines {
+			var sig syscall.Signal
+			var at int64
+			fmt.Sscanf(line, "%d %d", &sig, &at)
+			if sig != c.signals[i] {
+				t.Errorf("%s: signal %d is %v, want %v", c.name, i, sig, c.signals[i])
+			}
+			// 下一个信号在上一步的等待时间之后才发送，时间是子进程收到信号时记录的，信号的投递有延迟
+			if i > 0 && time.Duration(at-last) < c.waits[i-1]-signalTolerance {
+				t.Errorf("%s: %v sent %s after %v, want at least %s", c.name, sig, time.Duration(at-last), c.signals[i-1], c.waits[i-1])
+			}
+			last = at
+		}
+	}
+}
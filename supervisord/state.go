@@ -0,0 +1,91 @@
+package supervisord
+
+import (
+	"fmt"
+	"time"
+)
+
+type ProgramState string
+
+const (
+	ProcessStateStarting ProgramState = "Starting"
+	ProcessStateRunning  ProgramState = "Running"
+	ProcessStateStopping ProgramState = "Stopping"
+	ProcessStateStopped  ProgramState = "Stopped"
+	ProcessStateExited   ProgramState = "Exited"
+	ProcessStateFatal    ProgramState = "Fatal"
+	ProcessStateUnknown  ProgramState = "Unknown"
+	// 重启时新的进程没有就绪，老的进程仍在运行
+	ProcessStateRestartFailed ProgramState = "RestartFailed"
+	// 进程异常退出，等待一段时间之后重启
+	ProcessStateBackoff ProgramState = "Backoff"
+)
+
+// 每个状态允许转换到的状态
+var stateTransitions = map[ProgramState][]ProgramState{
+	ProcessStateStopped:       {ProcessStateStarting, ProcessStateFatal},
+	ProcessStateStarting:      {ProcessStateRunning, ProcessStateStopping, ProcessStateBackoff, ProcessStateExited, ProcessStateFatal, ProcessStateRestartFailed, ProcessStateUnknown},
+	ProcessStateRunning:       {ProcessStateStarting, ProcessStateStopping, ProcessStateBackoff, ProcessStateExited, ProcessStateFatal, ProcessStateUnknown},
+	ProcessStateRestartFailed: {ProcessStateStarting, ProcessStateStopping, ProcessStateBackoff, ProcessStateExited, ProcessStateFatal, ProcessStateUnknown},
+	ProcessStateBackoff:       {ProcessStateStarting, ProcessStateStopping, ProcessStateFatal},
+	ProcessStateStopping:      {ProcessStateStopped, ProcessStateUnknown},
+	ProcessStateExited:        {ProcessStateStarting},
+	ProcessStateFatal:         {ProcessStateStarting},
+	ProcessStateUnknown:       {ProcessStateStarting, ProcessStateStopping},
+}
+
+// 最多保留的状态转换记录
+const maxStateHistory = 50
+
+type StateTransition struct {
+	From   ProgramState `json:"from"`
+	To     ProgramState `json:"to"`
+	Time   int64        `json:"time"`
+	Reason string       `json:"reason"`
+}
+
+// 非法的状态转换
+type StateTransitionError struct {
+	Program string
+	From    ProgramState
+	To      ProgramState
+}
+
+func (e *StateTransitionError) Error() string {
+	return fmt.Sprintf("program %s can not change from %s to %s", e.Program, e.From, e.To)
+}
+
+func canTransition(from, to ProgramState) bool {
+	for _, s := range stateTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 将程序转换到新的状态，并记录转换的时间和原因，调用时需要持有program.lock
+func (program *Program) transition(to ProgramState, reason string) error {
+	from := program.status.State
+	if !canTransition(from, to) {
+		return &StateTransitionError{Program: program.Name, From: from, To: to}
+	}
+	program.status.State = to
+	program.history = append(program.history, &StateTransition{
+		From:   from,
+		To:     to,
+		Time:   time.Now().Unix(),
+		Reason: reason,
+	})
+	if len(program.history) > maxStateHistory {
+		program.history = program.history[len(program.history)-maxStateHistory:]
+	}
+	program.logger.Printf("%s -> %s: %s", from, to, reason)
+	return nil
+}
+
+func (program *Program) History() []*StateTransition {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	return append([]*StateTransition(nil), program.history...)
+}
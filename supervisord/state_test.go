@@ -0,0 +1,31 @@
+package supervisord
+
+import "testing"
+
+func Test_StateTransition(t *testing.T) {
+	prog, err := NewProgram("test", &ProgramConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.transition(ProcessStateRunning, "skip starting"); err == nil {
+		t.Error("Stopped -> Running should fail")
+	} else if _, ok := err.(*StateTransitionError); !ok {
+		t.Errorf("unexpected error type %T", err)
+	}
+	for _, to := range []ProgramState{ProcessStateStarting, ProcessStateRunning, ProcessStateStopping, ProcessStateStopped} {
+		if err := prog.transition(to, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history := prog.History()
+	if len(history) != 4 || history[0].From != ProcessStateStopped || history[3].To != ProcessStateStopped {
+		t.Errorf("unexpected history %+v", history)
+	}
+	if prog.Status().State != ProcessStateStopped {
+		t.Errorf("unexpected state %s", prog.Status().State)
+	}
+	// 被stop的程序不会因为迟到的进程退出而变为Exited
+	if err := prog.transition(ProcessStateExited, "late exit"); err == nil {
+		t.Error("Stopped -> Exited should fail")
+	}
+}
@@ -0,0 +1,359 @@
+package supervisord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// 升级时通过该环境变量将保存程序状态的文件描述符传递给新的supergo，
+// 状态较大时可能超过单个环境变量的长度限制，因此不直接放在环境变量中
+const UpgradeStateEnv = "SUPERGO_UPGRADE_STATE"
+
+// 升级时传递给新supergo的程序状态，文件描述符在exec之后仍然有效
+type upgradeProgram struct {
+	State        ProgramState       `json:"state"`
+	Pid          int                `json:"pid,omitempty"`
+	Child        bool               `json:"child,omitempty"` // 进程是否为supergo的子进程，接管的进程只能轮询
+	StartTime    int64              `json:"start_time,omitempty"`
+	StopTime     int64              `json:"stop_time,omitempty"`
+	StatusText   string             `json:"status_text,omitempty"`
+	ListenAddrs  []string           `json:"listen_addrs,omitempty"`
+	ListenFds    []int              `json:"listen_fds,omitempty"`
+	NotifySocket string             `json:"notify_socket,omitempty"`
+	NotifyFd     int                `json:"notify_fd,omitempty"`
+	StdoutFd     int                `json:"stdout_fd,omitempty"` // 进程输出管道的读端
+	StderrFd     int                `json:"stderr_fd,omitempty"`
+	History      []*StateTransition `json:"history,omitempty"`
+	CgroupDir    string             `json:"cgroup_dir,omitempty"` // 进程所在的cgroup，停止之后删除
+}
+
+var (
+	upgradeLock     sync.Mutex
+	upgradePrograms map[string]*upgradeProgram
+	// 已经被继承或者关闭的listener，numprocs的多个实例传递的是相同的文件描述符，不能重复使用或者关闭
+	takenFds = make(map[int]bool)
+)
+
+// 将所有程序的状态以及listener传递给exe并exec，子进程仍然是supergo的子进程，不需要重启，
+// 成功时不会返回
+func (supervisor *Supervisor) Upgrade(exe string) error {
+	progs := supervisor.ListPrograms()
+	// 阻止start、stop、restart等命令以及启动新的进程，保证传递的状态是完整的
+	for _, prog := range progs {
+		prog.cmdLock.Lock()
+		defer prog.cmdLock.Unlock()
+	}
+	childReaper.forkLock.Lock()
+	defer childReaper.forkLock.Unlock()
+
+	state := make(map[string]*upgradeProgram)
+	var fds []int
+	for _, prog := range progs {
+		up := prog.upgradeState()
+		state[prog.Name] = up
+		fds = append(fds, up.ListenFds...)
+		if up.NotifySocket != "" {
+			fds = append(fds, up.NotifyFd)
+		}
+		fds = append(fds, up.outputFds()...)
+	}
+	f, err := writeUpgradeState(state)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fds = append(fds, int(f.Fd()))
+
+	for _, fd := range fds {
+		setCloseOnExec(fd, false)
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, UpgradeStateEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, UpgradeStateEnv+"="+strconv.Itoa(int(f.Fd())))
+	// 在创建子进程的线程中exec，设置了pdeathsig的进程不会收到信号
+	onForkThread(func() {
+		err = syscall.Exec(exe, os.Args, env)
+	})
+	// exec失败，恢复close-on-exec，防止文件描述符泄露给之后启动的进程
+	for _, fd := range fds {
+		setCloseOnExec(fd, true)
+	}
+	return err
+}
+
+func (program *Program) upgradeState() *upgradeProgram {
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	up := &upgradeProgram{
+		State:      program.status.State,
+		StartTime:  program.status.StartTime,
+		StopTime:   program.status.StopTime,
+		StatusText: program.status.StatusText,
+		History:    program.history,
+		CgroupDir:  program.cgroupDir,
+	}
+	if program.listenerInited {
+		up.ListenAddrs = program.cfg.ListenAddrs
+		for _, f := range program.files {
+			up.ListenFds = append(up.ListenFds, int(f.Fd()))
+		}
+	}
+	if process := program.process; process != nil && !process.standby {
+		up.Pid = process.pid
+		// 自己启动的或者升级时继承的进程是子进程，重新启动之后接管的进程不是
+		up.Child = process.cmd != nil || process.proc != nil
+		up.StartTime = process.startTime
+		if process.notify != nil {
+			if raw, err := process.notify.conn.SyscallConn(); err == nil {
+				raw.Control(func(fd uintptr) {
+					up.NotifyFd = int(fd)
+				})
+				up.NotifySocket = process.notify.path
+			}
+		}
+		up.StdoutFd = pipeFd(process.stdoutPipe)
+		up.StderrFd = pipeFd(process.stderrPipe)
+	}
+	return up
+}
+
+// 不能使用Fd()，Fd()会将文件描述符设置为阻塞模式，管道已经关闭时返回0
+func pipeFd(f *os.File) int {
+	if f == nil {
+		return 0
+	}
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	fd := 0
+	if raw.Control(func(p uintptr) { fd = int(p) }) != nil {
+		return 0
+	}
+	return fd
+}
+
+func (up *upgradeProgram) outputFds() []int {
+	var fds []int
+	for _, fd := range []int{up.StdoutFd, up.StderrFd} {
+		if fd != 0 {
+			fds = append(fds, fd)
+		}
+	}
+	return fds
+}
+
+func setCloseOnExec(fd int, on bool) {
+	flag := 0
+	if on {
+		flag = syscall.FD_CLOEXEC
+	}
+	syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFD, uintptr(flag))
+}
+
+// 状态写入已经删除的临时文件，exec之后新的supergo从文件描述符读取
+func writeUpgradeState(state map[string]*upgradeProgram) (*os.File, error) {
+	f, err := ioutil.TempFile("", "supergo-upgrade")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	if err = json.NewEncoder(f).Encode(state); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// 读取升级之前的supergo传递的状态，需要在启用subreaper之前调用，防止子进程被当作孤儿进程回收
+func LoadUpgradeState() error {
+	v := os.Getenv(UpgradeStateEnv)
+	if v == "" {
+		return nil
+	}
+	os.Unsetenv(UpgradeStateEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", UpgradeStateEnv, v)
+	}
+	return loadUpgradeState(os.NewFile(uintptr(fd), "upgrade-state"))
+}
+
+func loadUpgradeState(f *os.File) error {
+	defer f.Close()
+	state := make(map[string]*upgradeProgram)
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return err
+	}
+	upgradeLock.Lock()
+	defer upgradeLock.Unlock()
+	upgradePrograms = state
+	for _, up := range state {
+		// 继承的文件描述符不能再传递给之后启动的进程
+		for _, fd := range up.ListenFds {
+			syscall.CloseOnExec(fd)
+		}
+		if up.NotifySocket != "" {
+			syscall.CloseOnExec(up.NotifyFd)
+		}
+		for _, fd := range up.outputFds() {
+			syscall.CloseOnExec(fd)
+		}
+		if up.Child {
+			childReaper.addChild(up.Pid)
+		}
+	}
+	return nil
+}
+
+func takeUpgradeProgram(name string) *upgradeProgram {
+	upgradeLock.Lock()
+	defer upgradeLock.Unlock()
+	up := upgradePrograms[name]
+	delete(upgradePrograms, name)
+	return up
+}
+
+// 配置的端口没有变化时，使用升级之前的listener，返回false时需要新建listener，调用时需要持有program.lock
+func (program *Program) inheritListener() bool {
+	upgradeLock.Lock()
+	defer upgradeLock.Unlock()
+	up := upgradePrograms[program.Name]
+	if up == nil || len(up.ListenFds) == 0 {
+		return false
+	}
+	fds := up.ListenFds
+	up.ListenFds = nil
+	if takenFds[fds[0]] {
+		return false
+	}
+	for _, fd := range fds {
+		takenFds[fd] = true
+	}
+	if !reflect.DeepEqual(up.ListenAddrs, program.cfg.ListenAddrs) {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return false
+	}
+	program.files = nil
+	for i, fd := range fds {
+		program.files = append(program.files, os.NewFile(uintptr(fd), up.ListenAddrs[i]))
+	}
+	program.listenerInited = true
+	return true
+}
+
+// 升级之后的supergo中，恢复程序的状态并接管仍在运行的进程，返回false时表示升级之前没有该程序
+func (program *Program) Inherit() (bool, error) {
+	up := takeUpgradeProgram(program.Name)
+	if up == nil {
+		return false, nil
+	}
+	program.lock.Lock()
+	program.history = up.History
+	program.status.StopTime = up.StopTime
+	program.cgroupDir = up.CgroupDir
+	program.lock.Unlock()
+
+	if up.Pid == 0 {
+		switch up.State {
+		case ProcessStateStopped, ProcessStateExited, ProcessStateFatal:
+			// 保持升级之前的状态，不启动进程。这不是一次新的状态转换，升级之前的转换已经在history中，
+			// 所以直接赋值而不经过transition，状态机中Stopped本来也不能转换为Exited
+			program.lock.Lock()
+			program.status.State = up.State
+			program.lock.Unlock()
+			return true, nil
+		}
+		// Backoff等状态的程序重新启动
+		return true, program.StartProcess()
+	}
+
+	var notify *notifySocket
+	if up.NotifySocket != "" {
+		f := os.NewFile(uintptr(up.NotifyFd), up.NotifySocket)
+		// FileConn会复制文件描述符
+		if conn, err := net.FileConn(f); err == nil {
+			notify = &notifySocket{conn: conn.(*net.UnixConn), path: up.NotifySocket}
+			passCredentials(notify.conn)
+		}
+		f.Close()
+	}
+	process := &Process{
+		pid:        up.Pid,
+		adopted:    true,
+		startTime:  up.StartTime,
+		statusText: up.StatusText,
+	}
+	// 升级之前的OOM kill没有杀死进程，只统计之后的
+	if up.CgroupDir != "" {
+		process.oomKills = readCgroupStats(up.CgroupDir).OOMKills
+	}
+	// 子进程可以wait，其余的进程轮询是否还存在
+	if up.Child {
+		proc, err := os.FindProcess(up.Pid)
+		if err != nil {
+			return true, err
+		}
+		process.proc = proc
+	}
+	// 继续读取进程的输出，新的配置中没有日志文件时丢弃
+	cred, _ := program.cfg.credential()
+	if up.StdoutFd != 0 {
+		process.stdoutPipe = os.NewFile(uintptr(up.StdoutFd), "stdout")
+	}
+	if up.StderrFd != 0 {
+		process.stderrPipe = os.NewFile(uintptr(up.StderrFd), "stderr")
+	}
+	program.copyOutputs(process, cred)
+	childReaper.addGroup(up.Pid, program)
+	program.cmdLock.Lock()
+	defer program.cmdLock.Unlock()
+	return true, program.attachProcess(process, notify, "upgrade")
+}
+
+// 升级之后配置中已经没有的程序，关闭继承的文件描述符并停止其进程
+func ReleaseUpgradeState() {
+	upgradeLock.Lock()
+	defer upgradeLock.Unlock()
+	for name, up := range upgradePrograms {
+		for _, fd := range up.ListenFds {
+			if !takenFds[fd] {
+				takenFds[fd] = true
+				syscall.Close(fd)
+			}
+		}
+		if up.NotifySocket != "" {
+			syscall.Close(up.NotifyFd)
+			os.Remove(up.NotifySocket)
+		}
+		for _, fd := range up.outputFds() {
+			syscall.Close(fd)
+		}
+		if up.Pid != 0 {
+			log.Printf("program %s is removed, stop process %d", name, up.Pid)
+			syscall.Kill(up.Pid, syscall.SIGTERM)
+			childReaper.removeChild(up.Pid)
+		}
+	}
+	upgradePrograms = nil
+	takenFds = make(map[int]bool)
+}
@@ -0,0 +1,138 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 升级时传递的状态经过文件描述符之后，子进程可以wait，接管的进程仍然轮询
+func Test_UpgradeStateInherit(t *testing.T) {
+	child := exec.Command(os.Args[0], "-test.run=^Test_HelperProcess$")
+	child.Env = append(os.Environ(), helperEnv+"=1")
+	if err := startChild(child); err != nil {
+		t.Fatal(err)
+	}
+	// 由sh启动之后sh退出，不是测试程序的子进程
+	out, err := exec.Command("sh", "-c", "sleep 30 >/dev/null 2>&1 & echo $!").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	adoptedPid, _ := strconv.Atoi(string(out[:len(out)-1]))
+	defer syscall.Kill(adoptedPid, syscall.SIGKILL)
+
+	processes := map[string]*Process{
+		"child":   {pid: child.Process.Pid, cmd: child},
+		"adopted": {pid: adoptedPid, adopted: true},
+	}
+	// 子进程所在的cgroup，升级之后仍然统计并在停止之后删除
+	cgroupDir := filepath.Join(t.TempDir(), "child")
+	os.Mkdir(cgroupDir, 0755)
+	state := make(map[string]*upgradeProgram)
+	for name, process := range processes {
+		prog, err := NewProgram(name, helperConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog.process = process
+		prog.status.State = ProcessStateRunning
+		if name == "child" {
+			prog.cgroupDir = cgroupDir
+		}
+		state[name] = prog.upgradeState()
+	}
+	exited, _ := NewProgram("exited", helperConfig())
+	exited.status.State = ProcessStateExited
+	state["exited"] = exited.upgradeState()
+	if !state["child"].Child || state["adopted"].Child {
+		t.Fatalf("child flags: %v %v", state["child"].Child, state["adopted"].Child)
+	}
+	f, err := writeUpgradeState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadUpgradeState(f); err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseUpgradeState()
+
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	for name, process := range processes {
+		cfg := helperConfig()
+		cfg.AutoRestart = false
+		prog, _ := supervisor.AddProgram(name, cfg)
+		if ok, err := prog.Inherit(); !ok || err != nil {
+			t.Fatalf("inherit %s: %v %v", name, ok, err)
+		}
+		prog.lock.Lock()
+		inherited := prog.process
+		prog.lock.Unlock()
+		if inherited.pid != process.pid || (inherited.proc != nil) != (name == "child") {
+			t.Fatalf("%s: inherited pid %d, proc %v", name, inherited.pid, inherited.proc)
+		}
+	}
+	prog, _ := supervisor.AddProgram("exited", helperConfig())
+	if ok, err := prog.Inherit(); !ok || err != nil {
+		t.Fatalf("inherit exited: %v %v", ok, err)
+	}
+	if s := prog.Status().State; s != ProcessStateExited {
+		t.Fatalf("exited: state %s", s)
+	}
+	if supervisor.GetProgram("child").Status().Cgroup == nil {
+		t.Fatal("cgroup stats lost after upgrade")
+	}
+	// 接管的进程在轮询时没有因为wait失败而被认为已经退出
+	time.Sleep(adoptPollInterval * 2)
+	for name := range processes {
+		if s := supervisor.GetProgram(name).Status(); s.State != ProcessStateRunning {
+			t.Fatalf("%s is %s", name, s.State)
+		}
+	}
+	if err := supervisor.StopProgram("child"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cgroupDir); !os.IsNotExist(err) {
+		t.Fatalf("cgroup %s is not removed after stop", cgroupDir)
+	}
+	syscall.Kill(adoptedPid, syscall.SIGKILL)
+	waitFor(t, adoptPollInterval*5, func() bool {
+		return supervisor.GetProgram("adopted").Status().State == ProcessStateExited
+	}, "adopted process exit is not detected")
+}
+
+// 升级之后设置了pdeathsig的进程仍在运行
+func Test_UpgradePdeathsig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supergo-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_HelperProcess$")
+	cmd.Env = append(os.Environ(), helperEnv+"=1", helperModeEnv+"=upgrade", helperPidEnv+"="+pidFile)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		cmd.Wait()
+	}()
+
+	var pid int
+	waitFor(t, time.Second*10, func() bool {
+		data, _ := ioutil.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(string(data))
+		return pid != 0
+	}, "process is not inherited after upgrade")
+	defer syscall.Kill(pid, syscall.SIGKILL)
+	time.Sleep(time.Millisecond * 500)
+	if err := syscall.Kill(pid, 0); err != nil {
+		t.Fatalf("process %d is killed after upgrade: %v", pid, err)
+	}
+}
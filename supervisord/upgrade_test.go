@@ -0,0 +1,37 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 升级之前启动一个设置了pdeathsig的进程，然后exec测试程序本身，
+// exec之后的测试程序继承该进程并写入其pid
+func upgradeHelper() {
+	cfg := helperConfig()
+	cfg.Pdeathsig = "KILL"
+	cfg.Environment = EnvMap{helperEnv: "1", helperModeEnv: ""}
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	if os.Getenv(UpgradeStateEnv) != "" {
+		if err := LoadUpgradeState(); err != nil {
+			os.Exit(3)
+		}
+		program, _ := supervisor.AddProgram("child", cfg)
+		if ok, err := program.Inherit(); !ok || err != nil {
+			os.Exit(4)
+		}
+		ioutil.WriteFile(os.Getenv(helperPidEnv), []byte(strconv.Itoa(program.Status().Pid)), 0644)
+		return
+	}
+	program, _ := supervisor.AddProgram("child", cfg)
+	if err := program.StartProcess(); err != nil {
+		os.Exit(5)
+	}
+	for program.Status().State != ProcessStateRunning {
+		time.Sleep(time.Millisecond * 50)
+	}
+	supervisor.Upgrade(os.Args[0])
+	os.Exit(6)
+}
@@ -0,0 +1,41 @@
+package supervisord
+
+import (
+	"strconv"
+	"time"
+)
+
+// 子进程需要在该环境变量指定的微秒数内发送WATCHDOG=1，同systemd
+const WatchdogUsecEnv = "WATCHDOG_USEC"
+
+func watchdogEnv(sec int) string {
+	return WatchdogUsecEnv + "=" + strconv.FormatInt(int64(sec)*int64(time.Second/time.Microsecond), 10)
+}
+
+// 进程就绪之后，如果超过watchdog_sec没有收到WATCHDOG=1，则认为进程已经挂起，将其停止后重启
+func (program *Program) watchdog(process *Process) {
+	timeout := time.Second * time.Duration(program.cfg.WatchdogSec)
+	process.lastKeepalive.Store(time.Now().UnixNano())
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-process.stopChan:
+			return
+		case <-ticker.C:
+		}
+		last := time.Unix(0, process.lastKeepalive.Load())
+		if time.Since(last) > timeout {
+			program.lock.Lock()
+			if process.stopping {
+				program.lock.Unlock()
+				return
+			}
+			process.hung = true
+			program.lock.Unlock()
+			program.logger.Printf("watchdog timeout, no keepalive since %s", last.Format("2006-01-02 15:04:05"))
+			program.stopProc(process)
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package supervisord
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 进程持续发送WATCHDOG=1时保持运行，停止发送之后超过watchdog_sec被重启
+func Test_Watchdog(t *testing.T) {
+	cfg := notifyConfig("READY=1")
+	cfg.WatchdogSec = 1
+	supervisor := NewSupervisor(&SupervisorConfig{ProgramConfigs: make(map[string]*ProgramConfig)})
+	defer supervisor.Exit()
+	program, err := supervisor.AddProgram("watchdog", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := program.StartProcess(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		return program.Status().State == ProcessStateRunning
+	}, "not running")
+	pid := program.Status().Pid
+
+	time.Sleep(time.Second * 3)
+	if s := program.Status(); s.State != ProcessStateRunning || s.Pid != pid {
+		t.Fatalf("got %s with pid %d while sending keepalives", s.State, s.Pid)
+	}
+	// 模拟挂起的进程
+	syscall.Kill(pid, syscall.SIGUSR2)
+	waitFor(t, time.Second*5, processExited(pid), "hung process is not stopped")
+	waitFor(t, time.Second*5, func() bool {
+		s := program.Status()
+		return s.State == ProcessStateRunning && s.Pid != pid
+	}, "hung process is not restarted")
+	pid = program.Status().Pid
+	time.Sleep(time.Second * 3)
+	if s := program.Status(); s.State != ProcessStateRunning || s.Pid != pid {
+		t.Fatalf("got %s with pid %d after restart", s.State, s.Pid)
+	}
+}